@@ -17,6 +17,22 @@ func LoadEnv() error {
 		return err
 	}
 
+	if err := env.Parse(&config.CloneCache); err != nil {
+		return err
+	}
+
+	if err := env.Parse(&config.Worker); err != nil {
+		return err
+	}
+
+	if err := env.Parse(&config.Server); err != nil {
+		return err
+	}
+
+	if err := env.Parse(&config.Poll); err != nil {
+		return err
+	}
+
 	Config = config
 
 	return nil
@@ -16,6 +16,52 @@ type config struct {
 		LogLevel   string        `env:"LOG_LEVEL"`
 		ConfigPath string        `env:"CONFIG_PATH"`
 		Interval   time.Duration `env:"INTERVAL" envDefault:"10s"`
+		// CONFIG_PATHの変更を確認する間隔 0の場合はSIGHUPを受けたときだけ読み直す
+		// e.g. 30s
+		ConfigReloadInterval time.Duration `env:"CONFIG_RELOAD_INTERVAL" envDefault:"10s"`
+		// e.g. sqs
+		// e.g. sqs,webhook
+		// e.g. poll
+		Mode []string `env:"MODE" envSeparator:"," envDefault:"sqs"`
+		// 同じリポジトリへの更新をまとめる時間 0の場合はまとめない
+		// e.g. 1m
+		BatchWindow time.Duration `env:"BATCH_WINDOW" envDefault:"0s"`
+		// trueの場合はコミットとpushをせず、差分と作成するPRを出力します
+		DryRun bool `env:"DRY_RUN" envDefault:"false"`
+	}
+
+	CloneCache struct {
+		// 空の場合はキャッシュを使わずイベントごとにcloneします
+		Dir        string        `env:"CLONE_CACHE_DIR"`
+		MaxEntries int           `env:"CLONE_CACHE_MAX_ENTRIES" envDefault:"10"`
+		MaxAge     time.Duration `env:"CLONE_CACHE_MAX_AGE" envDefault:"24h"`
+	}
+
+	Worker struct {
+		Count           int           `env:"WORKER_COUNT" envDefault:"4"`
+		QueueSize       int           `env:"WORKER_QUEUE_SIZE" envDefault:"32"`
+		JobTimeout      time.Duration `env:"WORKER_JOB_TIMEOUT" envDefault:"10m"`
+		ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"60s"`
+	}
+
+	Server struct {
+		Addr          string `env:"SERVER_ADDR" envDefault:":8080"`
+		WebhookSecret string `env:"WEBHOOK_SECRET"`
+		// trueの場合はWEBHOOK_SECRETなしで起動し、検証せずにリクエストを受け付けます
+		// ローカルでの動作確認など、外部から届かない環境でのみ使います
+		WebhookInsecure bool `env:"WEBHOOK_INSECURE" envDefault:"false"`
+	}
+
+	Poll struct {
+		Interval time.Duration `env:"POLL_INTERVAL" envDefault:"5m"`
+		// 確認済みのタグを保存するファイル 再起動で消えると停止中にpushされたタグを検知できないため、永続化したボリュームに置きます
+		// e.g. /var/lib/image-updater/poll-state.json
+		StatePath string `env:"POLL_STATE_PATH" envDefault:"/tmp/push-notify/poll-state.json"`
+		// REGISTRY_USERNAME, REGISTRY_PASSWORDを送るregistryのhost 他のregistryには送りません
+		// e.g. registry.example.com:5000
+		RegistryHost     string `env:"REGISTRY_HOST"`
+		RegistryUsername string `env:"REGISTRY_USERNAME"`
+		RegistryPassword string `env:"REGISTRY_PASSWORD"`
 	}
 
 	AWS struct {
@@ -4,17 +4,41 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
+	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/murasame29/image-registry-push-notify/sample-app/cmd/config"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/git"
 	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
 	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/poller"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/queue"
 	"github.com/murasame29/image-registry-push-notify/sample-app/internal/queue/aws"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/registry"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/server"
 	"github.com/murasame29/image-registry-push-notify/sample-app/internal/updater"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/worker"
+)
+
+const (
+	modeSQS     = "sqs"
+	modeWebhook = "webhook"
+	modePoll    = "poll"
+)
+
+// processFunc はイベントを更新処理に回し、更新が終わったらdoneを呼びます
+type processFunc func(ctx context.Context, event *model.ImageEvent, done updater.DoneFunc) error
+
+const (
+	visibilityTimeout        = 30 * time.Second
+	visibilityExtendInterval = 10 * time.Second
 )
 
 func init() {
@@ -25,87 +49,520 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(validate(os.Args[2:]))
+		case "replay":
+			os.Exit(replay(os.Args[2:]))
+		}
+	}
+
 	if err := run(); err != nil {
 		log.Error(context.Background(), "failed to run. error: %v", err)
 		os.Exit(1)
 	}
 }
 
+// validate は設定ファイルを検証し、問題があれば全て出力します
+// 引数がない場合はCONFIG_PATHを検証します
+// e.g. main validate ./config/setting.yaml
+func validate(paths []string) int {
+	if len(paths) == 0 {
+		paths = []string{config.Config.App.ConfigPath}
+	}
+
+	code := 0
+	for _, path := range paths {
+		registryConfigs, err := updater.NewConfigWithFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 1
+			continue
+		}
+
+		fmt.Printf("%s: ok (%d rules)\n", path, len(registryConfigs))
+	}
+
+	return code
+}
+
+// eventFiles は--eventを複数回指定するためのflagです
+type eventFiles []string
+
+func (f *eventFiles) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *eventFiles) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// replay はイベントのJSONファイルを読み込み、常駐時と同じ設定の照合と更新処理を実行します
+// ファイルの指定がないか"-"の場合は標準入力から読みます。ECR, registry:2, Harbor, GHCRのイベントを判別します
+// e.g. main replay --event event.json --dry-run
+// e.g. cat event.json | main replay --dry-run
+func replay(args []string) int {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	var files eventFiles
+	flags.Var(&files, "event", "event JSON file. \"-\" reads stdin (repeatable)")
+	configPath := flags.String("config", config.Config.App.ConfigPath, "config file")
+	dryRun := flags.Bool("dry-run", config.Config.App.DryRun, "print the diff instead of committing and pushing")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	files = append(files, flags.Args()...)
+	if len(files) == 0 {
+		files = eventFiles{"-"}
+	}
+
+	// 結果を標準出力に出すため、ログは標準エラーに出す
+	ctx := log.IntoContext(context.Background(), log.NewLogger(config.Config.App.LogLevel, os.Stderr))
+
+	registryConfigs, err := updater.NewConfigWithFile(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	appConfig := &updater.AppConfig{
+		LogLevel:                config.Config.App.LogLevel,
+		GitHubAppInstallationID: config.Config.GitHub.InstallationID,
+		GitHubApplicationID:     config.Config.GitHub.ApplicationID,
+		GitHubUsername:          config.Config.GitHub.Username,
+		GitHubAppCrtPath:        config.Config.GitHub.CrtPath,
+		RegistryConfig:          registryConfigs,
+		DryRun:                  *dryRun,
+	}
+
+	code := 0
+	for _, file := range files {
+		events, err := readEvents(file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", file, err)
+			code = 1
+			continue
+		}
+
+		if len(events) == 0 {
+			fmt.Printf("%s: no push event\n", file)
+		}
+
+		for _, event := range events {
+			if !replayEvent(ctx, appConfig, event) {
+				code = 1
+			}
+		}
+	}
+
+	return code
+}
+
+// readEvents はファイルか標準入力からイベントを読み込みます。JSONが複数続いている場合は全て読みます
+func readEvents(file string) ([]*model.ImageEvent, error) {
+	var r io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var events []*model.ImageEvent
+	decoder := json.NewDecoder(r)
+	for {
+		var data json.RawMessage
+		if err := decoder.Decode(&data); err != nil {
+			if errors.Is(err, io.EOF) {
+				return events, nil
+			}
+			return nil, fmt.Errorf("failed to decode event. error: %v", err)
+		}
+
+		parsed, err := model.ParseImageEvents(data)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, parsed...)
+	}
+}
+
+// replayEvent はイベントに一致した設定、更新するディレクトリ、更新の結果を出力し、失敗した場合はfalseを返します
+func replayEvent(ctx context.Context, appConfig *updater.AppConfig, event *model.ImageEvent) bool {
+	fmt.Printf("event: %s\n", event)
+
+	match, err := updater.MatchEvent(appConfig, event)
+	if err != nil {
+		fmt.Printf("  config: no match (%v)\n", err)
+		return false
+	}
+
+	fmt.Printf("  config: [%d] %s\n", match.Index, match.Config.RegitryURI)
+	fmt.Printf("  environment: %s\n", match.Environment)
+	fmt.Printf("  repository: %s\n", match.RepositoryDir)
+
+	pullRequestURL, err := updater.Update(ctx, appConfig, event)
+	switch {
+	case errors.Is(err, updater.ErrDuplicatePR):
+		fmt.Printf("  outcome: pull request already exists %s\n", pullRequestURL)
+	case err != nil && !validateUpdateError(err):
+		fmt.Printf("  outcome: skipped (%v)\n", err)
+	case err != nil:
+		fmt.Printf("  outcome: failed (%v)\n", err)
+		return false
+	case appConfig.DryRun || match.Config.DryRun:
+		fmt.Println("  outcome: dry run")
+	case pullRequestURL == "":
+		fmt.Println("  outcome: no changes")
+	default:
+		fmt.Printf("  outcome: pull request created %s\n", pullRequestURL)
+	}
+
+	return true
+}
+
 func run() error {
 	ctx := log.IntoContext(context.Background(), log.NewLogger(config.Config.App.LogLevel, os.Stdout))
 
 	log.Debug(ctx, "trying parse config...")
-	registryConfigs, err := updater.NewConfigWithFile(config.Config.App.ConfigPath)
+	configStore, err := updater.NewConfigStore(config.Config.App.ConfigPath)
 	if err != nil {
 		log.Error(ctx, "failed to parse config. error: %v", err)
 		return err
 	}
 
-	go func() {
-		for {
-			sqs, err := aws.NewSQS(config.Config.AWS.QueueURI, config.Config.AWS.RoleARN)
+	if config.Config.App.DryRun {
+		log.Info(ctx, "dry run enabled. changes are not committed or pushed")
+	}
+
+	pool := worker.NewPool(ctx, config.Config.Worker.Count, config.Config.Worker.QueueSize, config.Config.Worker.JobTimeout)
+
+	var cloneCache *git.CloneCache
+	if config.Config.CloneCache.Dir != "" {
+		cloneCache, err = git.NewCloneCache(config.Config.CloneCache.Dir, config.Config.CloneCache.MaxEntries, config.Config.CloneCache.MaxAge)
+		if err != nil {
+			log.Error(ctx, "failed to create clone cache. error: %v", err)
+			return err
+		}
+	}
+
+	appConfig := func() *updater.AppConfig {
+		return &updater.AppConfig{
+			LogLevel:                config.Config.App.LogLevel,
+			GitHubAppInstallationID: config.Config.GitHub.InstallationID,
+			GitHubApplicationID:     config.Config.GitHub.ApplicationID,
+			GitHubUsername:          config.Config.GitHub.Username,
+			GitHubAppCrtPath:        config.Config.GitHub.CrtPath,
+			RegistryConfig:          configStore.Get(),
+			CloneCache:              cloneCache,
+			DryRun:                  config.Config.App.DryRun,
+		}
+	}
+
+	// process はイベントをworkerのqueueに積み、更新が終わったらdoneを呼びます
+	// queueが溢れている場合はエラーを返します
+	var process processFunc = func(_ context.Context, event *model.ImageEvent, done updater.DoneFunc) error {
+		return pool.Submit(func(ctx context.Context) {
+			done(updater.Update(ctx, appConfig(), event))
+		})
+	}
+
+	var batcher *updater.Batcher
+	if config.Config.App.BatchWindow > 0 {
+		log.Info(ctx, "batching enabled. window: %s", config.Config.App.BatchWindow)
+		batcher = updater.NewBatcher(config.Config.App.BatchWindow, pool)
+		process = func(ctx context.Context, event *model.ImageEvent, done updater.DoneFunc) error {
+			batcher.Add(ctx, appConfig(), event, done)
+			return nil
+		}
+	}
+
+	// dispatch は結果をログに出すだけのイベントソース(webhook, poll)向けのprocessです
+	dispatch := func(ctx context.Context, event *model.ImageEvent) error {
+		return process(ctx, event, func(pullRequestURL string, err error) {
+			handleResult(ctx, pullRequestURL, err)
+		})
+	}
+
+	// receiveCtx はSIGTERMで受信側だけを止めるためのcontextです
+	// 実行中の更新処理はpoolのcontextで動くため、受信を止めても中断されません
+	receiveCtx, stopReceive := context.WithCancel(ctx)
+	defer stopReceive()
+
+	var (
+		srv       *server.Server
+		promoter  *updater.Promoter
+		receivers sync.WaitGroup
+	)
+	for _, mode := range config.Config.App.Mode {
+		switch mode {
+		case modeSQS:
+			source, err := aws.NewSQS(config.Config.AWS.QueueURI, config.Config.AWS.RoleARN)
 			if err != nil {
 				log.Error(ctx, "failed to create sqs client. error: %v", err)
-				continue
+				return err
 			}
 
-			messages, err := sqs.ReceiveMessage(ctx)
+			receivers.Add(1)
+			go func() {
+				defer receivers.Done()
+				consume(receiveCtx, source, process)
+			}()
+		case modeWebhook:
+			// シークレットがないと誰でもPRを作れるため、明示的に許可した場合だけ検証なしで起動する
+			if config.Config.Server.WebhookSecret == "" {
+				if !config.Config.Server.WebhookInsecure {
+					return errors.New("WEBHOOK_SECRET is required in webhook mode. set WEBHOOK_INSECURE=true to accept unverified requests")
+				}
+				log.Warn(ctx, "WEBHOOK_SECRET is empty and WEBHOOK_INSECURE is set. webhook requests are not verified")
+			}
+
+			srv = server.NewServer(ctx, config.Config.Server.Addr, config.Config.Server.WebhookSecret, dispatch)
+
+			// マージされた更新PRから次の環境への昇格を行う
+			promoter = updater.NewPromoter(configStore.Get, dispatch)
+			srv.HandlePullRequest(promoter.HandlePullRequest)
+
+			go func() {
+				if err := srv.Run(); err != nil {
+					log.Error(ctx, "failed to run webhook server. error: %v", err)
+				}
+			}()
+		case modePoll:
+			state, err := poller.LoadState(config.Config.Poll.StatePath)
 			if err != nil {
-				log.Error(ctx, "failed to receive message. error: %v", err)
-				continue
+				log.Error(ctx, "failed to load poll state. error: %v", err)
+				return err
 			}
 
-			for _, message := range messages {
-				go func(message types.Message) {
-					now := time.Now()
-					log.Debug(ctx, "event recieved! event: %v", message)
-
-					var eventBody *model.ECRPushEvent
-					if err := json.Unmarshal([]byte(*message.Body), &eventBody); err != nil {
-						log.Error(ctx, "failed to unmarshal event. error: %v", err)
-						return
-					}
-
-					log.Debug(ctx, "event recieved! event: %v", eventBody)
-
-					if err := updater.Update(ctx, &updater.AppConfig{
-						LogLevel:                config.Config.App.LogLevel,
-						GitHubAppInstallationID: config.Config.GitHub.InstallationID,
-						GitHubApplicationID:     config.Config.GitHub.ApplicationID,
-						GitHubUsername:          config.Config.GitHub.Username,
-						GitHubAppCrtPath:        config.Config.GitHub.CrtPath,
-						RegistryConfig:          registryConfigs,
-					}, eventBody); validateUpdateError(err) {
-						log.Error(ctx, "failed to update. error: %v", err)
-						return
-					}
-
-					log.Debug(ctx, "update successfly")
-					log.Debug(ctx, "trying delete message")
-
-					if err := sqs.DeleteMessage(ctx, *message.ReceiptHandle); err != nil {
-						log.Error(ctx, "failed to delete message. error: %v", err)
-						return
-					}
-
-					log.Debug(ctx, "delete message successfly duration: %d ms", time.Since(now).Milliseconds())
-				}(message)
+			credentials := make(map[string]registry.Credential)
+			if config.Config.Poll.RegistryUsername != "" {
+				if config.Config.Poll.RegistryHost == "" {
+					return errors.New("REGISTRY_HOST is required when REGISTRY_USERNAME is set")
+				}
+				credentials[config.Config.Poll.RegistryHost] = registry.Credential{
+					Username: config.Config.Poll.RegistryUsername,
+					Password: config.Config.Poll.RegistryPassword,
+				}
 			}
 
-			time.Sleep(config.Config.App.Interval)
+			client := registry.NewClient(credentials)
+			// 更新が終わってからタグを記録するため、結果をpollerに返す
+			p := poller.NewPoller(client, state, config.Config.Poll.Interval, func(ctx context.Context, event *model.ImageEvent, done func(error)) error {
+				return process(ctx, event, func(pullRequestURL string, err error) {
+					done(handleResult(ctx, pullRequestURL, err))
+				})
+			})
+
+			receivers.Add(1)
+			go func() {
+				defer receivers.Done()
+				p.Run(receiveCtx, func() []poller.Target {
+					return pollTargets(configStore.Get())
+				})
+			}()
+		default:
+			return fmt.Errorf("unknown mode: %s", mode)
 		}
-	}()
+	}
+
+	go reloadConfig(receiveCtx, configStore)
 
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGTERM, syscall.SIGINT)
 	<-sig
 
+	log.Info(ctx, "signal recieved. stopping receivers...")
+	stopReceive()
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, config.Config.Worker.ShutdownTimeout)
+	defer cancel()
+
+	if srv != nil {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Error(ctx, "failed to shutdown webhook server. error: %v", err)
+		}
+	}
+
+	receivers.Wait()
+
+	if promoter != nil {
+		promoter.Stop()
+	}
+
+	if batcher != nil {
+		batcher.FlushAll(ctx)
+	}
+
+	log.Info(ctx, "waiting for in-flight updates...")
+	if err := pool.Shutdown(shutdownCtx); err != nil {
+		log.Error(ctx, "failed to shutdown worker pool. error: %v", err)
+		return err
+	}
+
 	log.Info(ctx, "shutdown successfly by signal")
 
 	return nil
 }
 
+// reloadConfig はctxがキャンセルされるまで、SIGHUPを受けたときとCONFIG_RELOAD_INTERVALごとに設定を読み直します
+// 新しい設定が不正な場合は今の設定を使い続けます
+func reloadConfig(ctx context.Context, store *updater.ConfigStore) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	if interval := config.Config.App.ConfigReloadInterval; interval > 0 {
+		go store.Watch(ctx, interval)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Info(ctx, "SIGHUP recieved. reloading config...")
+			if err := store.Reload(ctx); err != nil {
+				log.Error(ctx, "failed to reload config. error: %v", err)
+			}
+		}
+	}
+}
+
+// consume はctxがキャンセルされるまでイベントソースからメッセージを受信し、更新処理に回します
+func consume(ctx context.Context, source queue.EventSource, process processFunc) {
+	for ctx.Err() == nil {
+		messages, err := source.Receive(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Error(ctx, "failed to receive message. error: %v", err)
+			sleep(ctx, config.Config.App.Interval)
+			continue
+		}
+
+		for _, message := range messages {
+			handleMessage(ctx, source, message, process)
+		}
+
+		sleep(ctx, config.Config.App.Interval)
+	}
+}
+
+// sleep はdの間かctxがキャンセルされるまで待ちます
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+// handleMessage はメッセージを更新処理に回し、成功した場合はAckします
+// 更新処理を受け付けられない場合はNackして他のレプリカや次回の受信に回します
+func handleMessage(ctx context.Context, source queue.EventSource, message queue.Message, process processFunc) {
+	now := time.Now()
+	log.Debug(ctx, "event recieved! id: %s", message.ID)
+
+	var eventBody *model.ECRPushEvent
+	if err := json.Unmarshal(message.Body, &eventBody); err != nil {
+		log.Error(ctx, "failed to unmarshal event. error: %v", err)
+		return
+	}
+
+	event := eventBody.ToImageEvent()
+	log.Debug(ctx, "event recieved! event: %v", event)
+
+	// 受信を止めた後もAckできるよう、受信側のキャンセルを引き継がない
+	ctx = context.WithoutCancel(ctx)
+
+	// 処理を待っている間も再配信されないよう、終わるまで可視性タイムアウトを延長し続ける
+	extendCtx, cancel := context.WithCancel(ctx)
+	go extendVisibility(extendCtx, source, message)
+
+	if err := process(ctx, event, func(pullRequestURL string, err error) {
+		defer cancel()
+
+		if err := handleResult(ctx, pullRequestURL, err); err != nil {
+			return
+		}
+
+		log.Debug(ctx, "trying delete message")
+
+		if err := source.Ack(ctx, message); err != nil {
+			log.Error(ctx, "failed to delete message. error: %v", err)
+			return
+		}
+
+		log.Debug(ctx, "delete message successfly duration: %d ms", time.Since(now).Milliseconds())
+	}); err != nil {
+		cancel()
+		log.Warn(ctx, "failed to process message. id: %s error: %v", message.ID, err)
+		if err := source.Nack(ctx, message); err != nil {
+			log.Error(ctx, "failed to nack message. id: %s error: %v", message.ID, err)
+		}
+	}
+}
+
+// handleResult は更新処理の結果をログに出し、内部で扱うエラー以外はそのまま返します
+func handleResult(ctx context.Context, pullRequestURL string, err error) error {
+	if validateUpdateError(err) {
+		log.Error(ctx, "failed to update. error: %v", err)
+		return err
+	}
+
+	log.Debug(ctx, "update successfly")
+	if pullRequestURL != "" {
+		log.Info(ctx, "pull request: %s", pullRequestURL)
+	}
+
+	return nil
+}
+
+// extendVisibility はctxがキャンセルされるまで定期的にメッセージの可視性タイムアウトを延長します
+func extendVisibility(ctx context.Context, source queue.EventSource, message queue.Message) {
+	ticker := time.NewTicker(visibilityExtendInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := source.Extend(ctx, message, visibilityTimeout); err != nil {
+				log.Warn(ctx, "failed to extend message visibility. id: %s error: %v", message.ID, err)
+			}
+		}
+	}
+}
+
+// pollTargets は設定からpollするrepositoryを取り出します
+func pollTargets(registryConfigs []updater.RegistryConfig) []poller.Target {
+	var targets []poller.Target
+	for _, registryConfig := range registryConfigs {
+		if registryConfig.Poll == nil {
+			continue
+		}
+
+		for _, repository := range registryConfig.Poll.Repositories {
+			targets = append(targets, poller.Target{
+				Repository:  repository,
+				MutableTags: registryConfig.Poll.MutableTags,
+			})
+		}
+	}
+
+	return targets
+}
+
 // validateUpdateError　は更新処理でエラーとして返されたエラーがinternalのエラーでないかを検証します
 func validateUpdateError(err error) bool {
-	return err != nil && !errors.Is(err, updater.ErrDuplicatePR) && !errors.Is(err, updater.ErrImageTagDeny) && !errors.Is(err, updater.ErrImageTagNotAllowed)
+	return err != nil && !errors.Is(err, updater.ErrDuplicatePR) && !errors.Is(err, updater.ErrImageTagDeny) && !errors.Is(err, updater.ErrImageTagNotAllowed) && !errors.Is(err, updater.ErrImageTagDowngrade)
 }
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/cmd/config"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/queue"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/queue/memory"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/updater"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/worker"
+)
+
+const testECREvent = `{
+  "account": "123456789012",
+  "detail": {
+    "action-type": "PUSH",
+    "image-digest": "sha256:0123",
+    "image-tag": "v1",
+    "repository-name": "example/app",
+    "result": "SUCCESS"
+  },
+  "detail-type": "ECR Image Action",
+  "id": "4f5ec4d5-4de4-7aad-a046-56d5cfe1df0e",
+  "region": "ap-northeast-1",
+  "source": "aws.ecr",
+  "time": "2019-08-06T00:58:09Z",
+  "version": "0"
+}`
+
+// recordingSource はAck/Nackされたメッセージを記録するEventSourceです
+type recordingSource struct {
+	*memory.Memory
+
+	mu     sync.Mutex
+	acked  []string
+	nacked []string
+	done   chan struct{}
+}
+
+func newRecordingSource() *recordingSource {
+	return &recordingSource{
+		Memory: memory.NewMemory(10*time.Millisecond, 10, time.Minute),
+		done:   make(chan struct{}, 10),
+	}
+}
+
+func (s *recordingSource) Ack(ctx context.Context, message queue.Message) error {
+	s.mu.Lock()
+	s.acked = append(s.acked, message.ID)
+	s.mu.Unlock()
+	s.notify()
+	return s.Memory.Ack(ctx, message)
+}
+
+func (s *recordingSource) Nack(ctx context.Context, message queue.Message) error {
+	s.mu.Lock()
+	s.nacked = append(s.nacked, message.ID)
+	s.mu.Unlock()
+	s.notify()
+	return s.Memory.Nack(ctx, message)
+}
+
+func (s *recordingSource) notify() {
+	select {
+	case s.done <- struct{}{}:
+	default:
+	}
+}
+
+// poolProcess はrunと同じようにworkerのqueueに積むprocessFuncです
+func poolProcess(pool *worker.Pool, update func(event *model.ImageEvent) (string, error)) processFunc {
+	return func(_ context.Context, event *model.ImageEvent, done updater.DoneFunc) error {
+		return pool.Submit(func(ctx context.Context) {
+			done(update(event))
+		})
+	}
+}
+
+func TestConsume(t *testing.T) {
+	config.Config.App.Interval = 10 * time.Millisecond
+
+	tests := []struct {
+		name      string
+		workers   int
+		queueSize int
+		wantAcked int
+		wantNack  bool
+	}{
+		{
+			name:      "更新が成功した場合はAckする",
+			workers:   1,
+			queueSize: 1,
+			wantAcked: 1,
+		},
+		{
+			name:      "workerのqueueが溢れている場合はNackする",
+			workers:   0,
+			queueSize: 0,
+			wantNack:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			pool := worker.NewPool(context.Background(), tt.workers, tt.queueSize, time.Second)
+			defer pool.Shutdown(context.Background())
+
+			var (
+				mu     sync.Mutex
+				events []*model.ImageEvent
+			)
+			process := poolProcess(pool, func(event *model.ImageEvent) (string, error) {
+				mu.Lock()
+				events = append(events, event)
+				mu.Unlock()
+				return "https://github.com/example/manifests/pull/1", nil
+			})
+
+			source := newRecordingSource()
+			id := source.Send([]byte(testECREvent))
+
+			consumed := make(chan struct{})
+			go func() {
+				defer close(consumed)
+				consume(ctx, source, process)
+			}()
+
+			select {
+			case <-source.done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("message was not acked or nacked")
+			}
+			cancel()
+			<-consumed
+
+			source.mu.Lock()
+			defer source.mu.Unlock()
+			// Nackしたメッセージはcancelするまでに再配信されることがあるため、Nackは1回以上あればよい
+			if len(source.acked) != tt.wantAcked || tt.wantNack != (len(source.nacked) != 0) {
+				t.Fatalf("acked = %v, nacked = %v", source.acked, source.nacked)
+			}
+			if tt.wantAcked != 0 && source.acked[0] != id {
+				t.Errorf("acked id = %s, want %s", source.acked[0], id)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if tt.wantAcked != 0 {
+				if len(events) != 1 {
+					t.Fatalf("events = %v", events)
+				}
+				if got := events[0].Reference(); got != "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app" {
+					t.Errorf("reference = %s", got)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleMessageRedeliversAfterNack(t *testing.T) {
+	ctx := context.Background()
+
+	pool := worker.NewPool(ctx, 0, 0, time.Second)
+	defer pool.Shutdown(ctx)
+
+	source := newRecordingSource()
+	source.Send([]byte(testECREvent))
+
+	messages, err := source.Receive(ctx)
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("Receive() = %v, %v", messages, err)
+	}
+
+	handleMessage(ctx, source, messages[0], poolProcess(pool, func(*model.ImageEvent) (string, error) {
+		return "", nil
+	}))
+
+	// Nackしたメッセージは再び受信できる
+	redelivered, err := source.Receive(ctx)
+	if err != nil || len(redelivered) != 1 || redelivered[0].ID != messages[0].ID {
+		t.Fatalf("Receive() after nack = %v, %v", redelivered, err)
+	}
+}
@@ -0,0 +1,162 @@
+// diff はファイルの変更をunified diffの形式で出力します
+// マニフェスト程度の大きさのファイルを想定し、行単位のLCSで差分を取ります
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines は変更の前後に出す変更していない行数です
+const contextLines = 3
+
+type opKind int
+
+const (
+	opEqual opKind = iota
+	opDelete
+	opInsert
+)
+
+type op struct {
+	kind opKind
+	line string
+	// 変更前と変更後の行番号 (0始まり)
+	before int
+	after  int
+}
+
+// Unified はbeforeとafterのunified diffを返します。差分がない場合は空文字を返します
+// e.g. --- a/overlays/dev/kustomization.yaml
+// e.g. +++ b/overlays/dev/kustomization.yaml
+func Unified(path string, before, after []byte) string {
+	ops := lineOps(splitLines(string(before)), splitLines(string(after)))
+
+	var out strings.Builder
+	for _, hunk := range hunks(ops) {
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- a/%s\n+++ b/%s\n", path, path)
+		}
+		writeHunk(&out, hunk)
+	}
+
+	return out.String()
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// lineOps はLCSから変更前の行を変更後の行に変える操作を返します
+func lineOps(a, b []string) []op {
+	// lcs[i][j] はa[i:]とb[j:]の最長共通部分列の長さ
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]op, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{kind: opEqual, line: a[i], before: i, after: j})
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{kind: opDelete, line: a[i], before: i, after: j})
+			i++
+		default:
+			ops = append(ops, op{kind: opInsert, line: b[j], before: i, after: j})
+			j++
+		}
+	}
+
+	return ops
+}
+
+// hunks は変更のある操作の前後contextLines行をまとめ、近い変更は1つのhunkにします
+func hunks(ops []op) [][]op {
+	var result [][]op
+
+	start, end := -1, -1
+	for i := range ops {
+		if ops[i].kind == opEqual {
+			continue
+		}
+
+		from := max(i-contextLines, 0)
+		if start != -1 && from > end {
+			result = append(result, ops[start:end])
+			start = -1
+		}
+		if start == -1 {
+			start = from
+		}
+		end = min(i+contextLines+1, len(ops))
+	}
+
+	if start != -1 {
+		result = append(result, ops[start:end])
+	}
+
+	return result
+}
+
+func writeHunk(out *strings.Builder, hunk []op) {
+	beforeCount, afterCount := 0, 0
+	for _, o := range hunk {
+		if o.kind != opInsert {
+			beforeCount++
+		}
+		if o.kind != opDelete {
+			afterCount++
+		}
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(hunk[0].before, beforeCount), hunkRange(hunk[0].after, afterCount))
+	for _, o := range hunk {
+		prefix := " "
+		switch o.kind {
+		case opDelete:
+			prefix = "-"
+		case opInsert:
+			prefix = "+"
+		}
+
+		out.WriteString(prefix + o.line)
+		if !strings.HasSuffix(o.line, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// hunkRange はhunkの開始行と行数を返します。行がない場合は直前の行番号を使います
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
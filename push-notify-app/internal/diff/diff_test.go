@@ -0,0 +1,113 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numbered は from から to までの番号を1行ずつ並べ、replaceに指定した行を置き換えます
+func numbered(from, to int, replace map[int]string) string {
+	var out strings.Builder
+	for i := from; i <= to; i++ {
+		if line, ok := replace[i]; ok {
+			out.WriteString(line + "\n")
+			continue
+		}
+		fmt.Fprintf(&out, "%d\n", i)
+	}
+
+	return out.String()
+}
+
+func TestUnified(t *testing.T) {
+	const header = "--- a/kustomization.yaml\n+++ b/kustomization.yaml\n"
+
+	// 期待値は diff -u の出力と同じです
+	tests := []struct {
+		name   string
+		before string
+		after  string
+		want   string
+	}{
+		{
+			name:   "差分がない",
+			before: "a\nb\n",
+			after:  "a\nb\n",
+			want:   "",
+		},
+		{
+			name:   "先頭に追加",
+			before: "b\nc\nd\n",
+			after:  "a\nb\nc\nd\n",
+			want:   "@@ -1,3 +1,4 @@\n+a\n b\n c\n d\n",
+		},
+		{
+			name:   "先頭を削除",
+			before: "a\nb\nc\n",
+			after:  "b\nc\n",
+			want:   "@@ -1,3 +1,2 @@\n-a\n b\n c\n",
+		},
+		{
+			name:   "末尾に追加",
+			before: "a\nb\n",
+			after:  "a\nb\nc\n",
+			want:   "@@ -1,2 +1,3 @@\n a\n b\n+c\n",
+		},
+		{
+			name:   "末尾を削除",
+			before: "a\nb\nc\nd\n",
+			after:  "a\nb\nc\n",
+			want:   "@@ -1,4 +1,3 @@\n a\n b\n c\n-d\n",
+		},
+		{
+			name:   "空のファイルに追加",
+			before: "",
+			after:  "a\n",
+			want:   "@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name:   "近い変更は1つのhunkにまとめる",
+			before: numbered(1, 10, nil),
+			after:  numbered(1, 10, map[int]string{2: "x", 9: "y"}),
+			want:   "@@ -1,10 +1,10 @@\n 1\n-2\n+x\n 3\n 4\n 5\n 6\n 7\n 8\n-9\n+y\n 10\n",
+		},
+		{
+			name:   "離れた変更は別のhunkにする",
+			before: numbered(1, 20, nil),
+			after:  numbered(1, 20, map[int]string{2: "x", 18: "y"}),
+			want:   "@@ -1,5 +1,5 @@\n 1\n-2\n+x\n 3\n 4\n 5\n@@ -15,6 +15,6 @@\n 15\n 16\n 17\n-18\n+y\n 19\n 20\n",
+		},
+		{
+			name:   "変更の間が7行以上ある場合は別のhunkにする",
+			before: numbered(1, 12, nil),
+			after:  numbered(1, 12, map[int]string{2: "x", 10: "y"}),
+			want:   "@@ -1,5 +1,5 @@\n 1\n-2\n+x\n 3\n 4\n 5\n@@ -7,6 +7,6 @@\n 7\n 8\n 9\n-10\n+y\n 11\n 12\n",
+		},
+		{
+			name:   "変更後の最終行に改行がない",
+			before: "a\nb\n",
+			after:  "a\nb",
+			want:   "@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name:   "変更前と変更後の最終行に改行がない",
+			before: "a\nb",
+			after:  "a\nc",
+			want:   "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := tt.want
+			if want != "" {
+				want = header + want
+			}
+
+			if got := Unified("kustomization.yaml", []byte(tt.before), []byte(tt.after)); got != want {
+				t.Errorf("Unified()\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package git
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+)
+
+// CloneCache はリポジトリURLごとにcloneしたディレクトリを保持し、次回以降はfetchとhard resetで再利用します
+// 同じエントリは同時に1つの更新処理だけが使えます
+type CloneCache struct {
+	dir        string
+	maxEntries int
+	maxAge     time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	url string
+	dir string
+	// branch はclone時のデフォルトブランチです
+	// e.g. refs/heads/main
+	branch   plumbing.ReferenceName
+	lastUsed time.Time
+
+	// sem はエントリを使用中かどうかを表します
+	sem chan struct{}
+}
+
+func NewCloneCache(dir string, maxEntries int, maxAge time.Duration) (*CloneCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create clone cache dir. error: %v", err)
+	}
+
+	// 前回の起動時のcloneは次に使われたときに再利用するが、maxAgeを過ぎたものはここで消す
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clone cache dir. error: %v", err)
+	}
+
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+		os.RemoveAll(filepath.Join(dir, file.Name())) // error: no check
+	}
+
+	return &CloneCache{
+		dir:        dir,
+		maxEntries: maxEntries,
+		maxAge:     maxAge,
+		entries:    make(map[string]*cacheEntry),
+	}, nil
+}
+
+// acquire はキャッシュからリポジトリを取り出し、リモートのデフォルトブランチと同じ状態にして返します
+// 使い終わったら返り値の関数でエントリを解放します
+// エントリは複数の設定で共有するため、opts.SparseDirectoriesは使わずDepthだけを使います
+func (c *CloneCache) acquire(ctx context.Context, url string, auth transport.AuthMethod, opts CloneOptions) (*git.Repository, string, func(), error) {
+	var entry *cacheEntry
+	for {
+		entry = c.entry(url)
+
+		select {
+		case entry.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, "", nil, ctx.Err()
+		}
+
+		// 待っている間にevictされた場合は作り直す
+		c.mu.Lock()
+		current := c.entries[url]
+		c.mu.Unlock()
+		if current == entry {
+			break
+		}
+		<-entry.sem
+	}
+
+	release := func() {
+		c.mu.Lock()
+		entry.lastUsed = time.Now()
+		c.mu.Unlock()
+		<-entry.sem
+	}
+
+	repo, err := c.refresh(ctx, entry, auth, opts.Depth)
+	if err != nil {
+		log.Warn(ctx, "failed to reuse cached clone. url: %s error: %v", url, err)
+
+		repo, err = c.clone(ctx, entry, auth, opts.Depth)
+		if err != nil {
+			release()
+			return nil, "", nil, err
+		}
+	}
+
+	c.evict(ctx)
+
+	return repo, entry.dir, release, nil
+}
+
+func (c *CloneCache) entry(url string) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if entry, ok := c.entries[url]; ok {
+		return entry
+	}
+
+	sum := sha256.Sum256([]byte(url))
+	entry := &cacheEntry{
+		url:      url,
+		dir:      filepath.Join(c.dir, hex.EncodeToString(sum[:])[:16]),
+		lastUsed: time.Now(),
+		sem:      make(chan struct{}, 1),
+	}
+	c.entries[url] = entry
+
+	return entry
+}
+
+// refresh はキャッシュ済みのリポジトリをfetchし、デフォルトブランチにhard resetします
+// 前回の更新で作ったローカルブランチや未コミットの変更は全て捨てます
+func (c *CloneCache) refresh(ctx context.Context, entry *cacheEntry, auth transport.AuthMethod, depth int) (*git.Repository, error) {
+	repo, err := git.PlainOpen(entry.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// 前回の起動時のcloneはHEADが更新用のブランチを指していることがあるため、リモートに問い合わせる
+	if entry.branch == "" {
+		branch, err := remoteDefaultBranch(ctx, repo, auth)
+		if err != nil {
+			return nil, err
+		}
+		entry.branch = branch
+	}
+
+	log.Debug(ctx, "trying fetch cached clone. url: %s", entry.url)
+	if err := repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: git.DefaultRemoteName,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+refs/heads/*:refs/remotes/%s/*", git.DefaultRemoteName))},
+		Auth:       auth,
+		Depth:      depth,
+		Force:      true,
+		Prune:      true,
+	}); err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil, fmt.Errorf("failed to fetch. error: %v", err)
+	}
+
+	remoteRef, err := repo.Reference(plumbing.NewRemoteReferenceName(git.DefaultRemoteName, entry.branch.Short()), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve remote branch. branch: %s error: %v", entry.branch, err)
+	}
+
+	workspace, err := repo.Worktree()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := workspace.Checkout(&git.CheckoutOptions{Branch: entry.branch, Force: true}); err != nil {
+		return nil, fmt.Errorf("failed to checkout default branch. error: %v", err)
+	}
+
+	if err := workspace.Reset(&git.ResetOptions{Commit: remoteRef.Hash(), Mode: git.HardReset}); err != nil {
+		return nil, fmt.Errorf("failed to reset. error: %v", err)
+	}
+
+	if err := workspace.Clean(&git.CleanOptions{Dir: true}); err != nil {
+		return nil, fmt.Errorf("failed to clean. error: %v", err)
+	}
+
+	branches, err := repo.Branches()
+	if err != nil {
+		return nil, err
+	}
+
+	var stale []plumbing.ReferenceName
+	_ = branches.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Name() != entry.branch {
+			stale = append(stale, ref.Name())
+		}
+		return nil
+	})
+
+	for _, name := range stale {
+		if err := repo.Storer.RemoveReference(name); err != nil {
+			return nil, fmt.Errorf("failed to remove branch. branch: %s error: %v", name, err)
+		}
+	}
+
+	log.Debug(ctx, "cached clone refreshed. url: %s commit: %s", entry.url, remoteRef.Hash())
+	return repo, nil
+}
+
+// remoteDefaultBranch はリモートのHEADが指すブランチを返します
+// e.g. refs/heads/main
+func remoteDefaultBranch(ctx context.Context, repo *git.Repository, auth transport.AuthMethod) (plumbing.ReferenceName, error) {
+	remote, err := repo.Remote(git.DefaultRemoteName)
+	if err != nil {
+		return "", err
+	}
+
+	refs, err := remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", fmt.Errorf("failed to list remote references. error: %v", err)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD && ref.Type() == plumbing.SymbolicReference {
+			return ref.Target(), nil
+		}
+	}
+
+	return "", fmt.Errorf("remote HEAD not found. remote: %s", git.DefaultRemoteName)
+}
+
+func (c *CloneCache) clone(ctx context.Context, entry *cacheEntry, auth transport.AuthMethod, depth int) (*git.Repository, error) {
+	if err := os.RemoveAll(entry.dir); err != nil {
+		return nil, fmt.Errorf("failed to remove cache dir. error: %v", err)
+	}
+
+	log.Debug(ctx, "trying clone into cache. url: %s dir: %s", entry.url, entry.dir)
+	repo, err := git.PlainCloneContext(ctx, entry.dir, false, &git.CloneOptions{
+		URL:   entry.url,
+		Auth:  auth,
+		Depth: depth,
+	})
+	if err != nil {
+		os.RemoveAll(entry.dir)
+		log.Error(ctx, "failed to clone repository. repository: %s error: %v", entry.url, err)
+		return nil, err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, err
+	}
+	entry.branch = head.Name()
+
+	return repo, nil
+}
+
+// evict は使われていないエントリのうち、maxAgeを過ぎたものとmaxEntriesを超えた古いものを削除します
+// ディレクトリの削除は時間がかかるため、ロック中は退避先にrenameするだけにして、削除はロックを外してから行います
+// 同じURLのエントリは同じディレクトリを使うため、renameせずに後から削除すると作り直したcloneを消してしまいます
+func (c *CloneCache) evict(ctx context.Context) {
+	var removed []string
+
+	c.mu.Lock()
+	entries := make([]*cacheEntry, 0, len(c.entries))
+	for _, entry := range c.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].lastUsed.After(entries[j].lastUsed)
+	})
+
+	for i, entry := range entries {
+		if i < c.maxEntries && time.Since(entry.lastUsed) < c.maxAge {
+			continue
+		}
+
+		// 使用中のエントリは削除しない
+		select {
+		case entry.sem <- struct{}{}:
+		default:
+			continue
+		}
+
+		log.Info(ctx, "evicting cached clone. url: %s last used: %s", entry.url, entry.lastUsed.Format(time.RFC3339))
+		evicted := fmt.Sprintf("%s.evicted.%d", entry.dir, time.Now().UnixNano())
+		if err := os.Rename(entry.dir, evicted); err == nil {
+			removed = append(removed, evicted)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			log.Warn(ctx, "failed to move cached clone. dir: %s error: %v", entry.dir, err)
+		}
+		delete(c.entries, entry.url)
+		<-entry.sem
+	}
+	c.mu.Unlock()
+
+	for _, dir := range removed {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Warn(ctx, "failed to remove cached clone. dir: %s error: %v", dir, err)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// commitFile はworktreeにファイルを書いてコミットします
+func commitFile(t *testing.T, repo *git.Repository, dir, name, content string) plumbing.Hash {
+	t.Helper()
+
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	workspace, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := workspace.Add(name); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := workspace.Commit("update "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return hash
+}
+
+func TestCloneCacheRefreshUsesRemoteDefaultBranch(t *testing.T) {
+	ctx := context.Background()
+
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, upstream, upstreamDir, "kustomization.yaml", "images: []\n")
+
+	head, err := upstream.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaultBranch := head.Name()
+
+	cacheDir := t.TempDir()
+	cache, err := NewCloneCache(cacheDir, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, dir, release, err := cache.acquire(ctx, upstreamDir, nil, CloneOptions{})
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+
+	// 更新処理と同じように更新用のブランチでコミットしてpushし、そのブランチのままにする
+	workspace, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName("image_updater_app_dev_v1"),
+		Create: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, repo, dir, "kustomization.yaml", "images: [app:v1]\n")
+	if err := repo.PushContext(ctx, &git.PushOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	want := commitFile(t, upstream, upstreamDir, "kustomization.yaml", "images: [app]\n")
+
+	// 再起動した場合と同じようにキャッシュを作り直す
+	restarted, err := NewCloneCache(cacheDir, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo, _, release, err = restarted.acquire(ctx, upstreamDir, nil, CloneOptions{})
+	if err != nil {
+		t.Fatalf("acquire() after restart error = %v", err)
+	}
+	defer release()
+
+	got, err := repo.Head()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name() != defaultBranch || got.Hash() != want {
+		t.Errorf("head = %s %s, want %s %s", got.Name(), got.Hash(), defaultBranch, want)
+	}
+}
+
+func TestCloneCacheEvict(t *testing.T) {
+	ctx := context.Background()
+
+	var upstreams []string
+	for range 2 {
+		upstreamDir := t.TempDir()
+		upstream, err := git.PlainInit(upstreamDir, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		commitFile(t, upstream, upstreamDir, "kustomization.yaml", "images: []\n")
+		upstreams = append(upstreams, upstreamDir)
+	}
+
+	cacheDir := t.TempDir()
+	cache, err := NewCloneCache(cacheDir, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, oldDir, release, err := cache.acquire(ctx, upstreams[0], nil, CloneOptions{})
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	release()
+
+	_, newDir, release, err := cache.acquire(ctx, upstreams[1], nil, CloneOptions{})
+	if err != nil {
+		t.Fatalf("acquire() error = %v", err)
+	}
+	defer release()
+
+	if _, err := os.Stat(oldDir); !os.IsNotExist(err) {
+		t.Errorf("evicted clone still exists. dir: %s error: %v", oldDir, err)
+	}
+
+	// 退避先のディレクトリも残さない
+	files, err := os.ReadDir(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || filepath.Join(cacheDir, files[0].Name()) != newDir {
+		t.Errorf("cache dir = %v, want only %s", files, newDir)
+	}
+}
@@ -2,13 +2,17 @@ package git
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/google/go-github/v63/github"
@@ -28,10 +32,13 @@ type GitHub struct {
 	autherEmail string
 
 	clinet *github.Client
+
+	// cache はnilの場合はイベントごとに一時ディレクトリへcloneします
+	cache *CloneCache
 }
 
-func NewGitHub(applicationID, installID int64, username, crtPath string) (*GitHub, error) {
-	client, token, err := NewGitHubApp(context.Background(), applicationID, installID, crtPath)
+func NewGitHub(ctx context.Context, applicationID, installID int64, username, crtPath string) (*GitHub, error) {
+	client, token, err := NewGitHubApp(ctx, applicationID, installID, crtPath)
 	if err != nil {
 		return nil, err
 	}
@@ -52,9 +59,11 @@ func (g *GitHub) Branch(ctx context.Context, repo *git.Repository, branch string
 		return err
 	}
 
+	// HEADから作るのでworktreeはそのまま使う (sparse checkoutの状態を崩さないため)
 	checkoutOption := &git.CheckoutOptions{
 		Branch: plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branch)),
 		Create: true,
+		Keep:   true,
 	}
 	if err := workspace.Checkout(checkoutOption); err != nil {
 		log.Error(ctx, "failed to check out. error: %v", err)
@@ -95,46 +104,161 @@ func fetchOrigin(ctx context.Context, repo *git.Repository, refSpecStr string) e
 	return nil
 }
 
-func (g *GitHub) Clone(ctx context.Context, repository string) (*git.Repository, string, error) {
+// CloneOptions はcloneする量を減らすためのオプションです
+type CloneOptions struct {
+	// Depth は取得するコミット履歴の深さです。0の場合は全履歴を取得します
+	Depth int
+	// SparseDirectories を指定した場合はそのディレクトリだけをworktreeに展開します
+	// e.g. services/sample/sample-app/app/overlays/dev
+	SparseDirectories []string
+}
+
+// Clone はリポジトリをcloneし、使い終わったときに呼ぶ関数を返します
+// clone cacheを使う場合はキャッシュ済みのリポジトリをデフォルトブランチにresetして返します
+func (g *GitHub) Clone(ctx context.Context, repository string, opts CloneOptions) (*git.Repository, string, func(), error) {
+	if g.cache != nil {
+		return g.cache.acquire(ctx, repository, g.auth(), opts)
+	}
+
 	repoName := strings.Split(repository, "/")[4]
-	dir := fmt.Sprintf("/tmp/%s_%d", repoName, time.Now().Unix())
-	repo, err := git.PlainClone(dir, false, &git.CloneOptions{
-		URL: repository,
-		Auth: &http.BasicAuth{
-			Username: g.username,
-			Password: g.token,
-		},
+	dir, err := os.MkdirTemp("", repoName+"_*")
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	sparse := len(opts.SparseDirectories) != 0
+	repo, err := git.PlainCloneContext(ctx, dir, false, &git.CloneOptions{
+		URL:        repository,
+		Auth:       g.auth(),
+		Depth:      opts.Depth,
+		NoCheckout: sparse,
 	})
 	if err != nil {
+		os.RemoveAll(dir)
 		log.Error(ctx, "failed to clone repository. repository: %s error: %v", repository, err)
-		return nil, "", err
+		return nil, "", nil, err
+	}
+
+	if sparse {
+		log.Debug(ctx, "trying sparse checkout. repository: %s dirs: %v", repository, opts.SparseDirectories)
+		if err := sparseCheckout(repo, opts.SparseDirectories); err != nil {
+			os.RemoveAll(dir)
+			log.Error(ctx, "failed to sparse checkout. repository: %s error: %v", repository, err)
+			return nil, "", nil, err
+		}
 	}
 
-	return repo, dir, nil
+	return repo, dir, func() {
+		os.RemoveAll(dir) // error: no check
+	}, nil
 }
 
-func (g *GitHub) Commit(ctx context.Context, repo *git.Repository, path string, message string) (string, error) {
+// sparseCheckout はHEADのうちdirsに含まれるファイルだけをworktreeに展開します
+// go-gitのsparse checkoutはworktreeにないエントリをindexから消してしまい、コミットすると削除扱いになるため
+// 消えたエントリをskip-worktreeとしてindexに戻します
+func sparseCheckout(repo *git.Repository, dirs []string) error {
+	head, err := repo.Head()
+	if err != nil {
+		return err
+	}
+
 	workspace, err := repo.Worktree()
 	if err != nil {
-		log.Error(ctx, "failed to open worktree. error: %v", err)
-		return "", err
+		return err
+	}
+
+	if err := workspace.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.MixedReset}); err != nil {
+		return fmt.Errorf("failed to reset index. error: %v", err)
 	}
 
-	log.Info(ctx, "trying git add. path: %s", path)
+	patterns := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		patterns = append(patterns, strings.Trim(dir, "/")+"/")
+	}
 
-	if _, err := workspace.Add("."); err != nil {
-		log.Error(ctx, "failed to git add. path: %s error: %v", path, err)
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+	idx.SkipUnless(patterns)
+	if err := repo.Storer.SetIndex(idx); err != nil {
+		return err
+	}
+
+	if err := workspace.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("failed to checkout. error: %v", err)
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return err
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return err
+	}
+
+	idx, err = repo.Storer.Index()
+	if err != nil {
+		return err
+	}
+
+	if err := tree.Files().ForEach(func(file *object.File) error {
+		if _, err := idx.Entry(file.Name); err == nil {
+			return nil
+		}
+		idx.Entries = append(idx.Entries, &index.Entry{
+			Name:         file.Name,
+			Hash:         file.Hash,
+			Mode:         file.Mode,
+			SkipWorktree: true,
+		})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return repo.Storer.SetIndex(idx)
+}
+
+// UseCloneCache はcloneにキャッシュを使うようにします
+func (g *GitHub) UseCloneCache(cache *CloneCache) {
+	g.cache = cache
+}
+
+func (g *GitHub) auth() *http.BasicAuth {
+	return &http.BasicAuth{
+		Username: g.username,
+		Password: g.token,
+	}
+}
+
+// Commit はworktreeからの相対パスで指定したファイルをaddしてコミットします
+func (g *GitHub) Commit(ctx context.Context, repo *git.Repository, paths []string, message string) (string, error) {
+	workspace, err := repo.Worktree()
+	if err != nil {
+		log.Error(ctx, "failed to open worktree. error: %v", err)
 		return "", err
 	}
 
-	log.Info(ctx, "git add successfuly path:%s", path)
+	for _, path := range paths {
+		log.Info(ctx, "trying git add. path: %s", path)
+
+		if _, err := workspace.Add(path); err != nil {
+			log.Error(ctx, "failed to git add. path: %s error: %v", path, err)
+			return "", err
+		}
+
+		log.Info(ctx, "git add successfuly path:%s", path)
+	}
 	status, err := workspace.Status()
 	if err != nil {
 		log.Error(ctx, "failed to git status. error: %v", err)
 		return "", err
 	}
 
-	log.Info(ctx, status.String())
+	log.Info(ctx, visibleStatus(repo, status).String())
 
 	log.Info(ctx, "trying git commit -m %s.", message)
 	commit, err := workspace.Commit(message, &git.CommitOptions{
@@ -147,20 +271,85 @@ func (g *GitHub) Commit(ctx context.Context, repo *git.Repository, path string,
 
 	if err != nil {
 		log.Error(ctx, "failed to git commit. error: %v", err)
+		return "", err
 	}
 
 	log.Info(ctx, "git commit successfuly")
 	return commit.String(), nil
 }
 
+// visibleStatus はstatusからskip-worktreeのエントリを除いたものを返します
+// sparse checkoutでworktreeに展開していないファイルはstatusでは削除(DD)と表示されるため、ログに出さないようにします
+func visibleStatus(repo *git.Repository, status git.Status) git.Status {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return status
+	}
+
+	skipped := make(map[string]bool)
+	for _, entry := range idx.Entries {
+		if entry.SkipWorktree {
+			skipped[entry.Name] = true
+		}
+	}
+
+	visible := make(git.Status, len(status))
+	for path, fileStatus := range status {
+		if !skipped[path] {
+			visible[path] = fileStatus
+		}
+	}
+
+	return visible
+}
+
+// HeadFile はHEADのコミットにあるファイルの内容を返します。ファイルがない場合は空を返します
+// path はworktreeからの相対パスです
+func (g *GitHub) HeadFile(repo *git.Repository, path string) ([]byte, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get head. error: %v", err)
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit. error: %v", err)
+	}
+
+	file, err := commit.File(filepath.ToSlash(path))
+	if errors.Is(err, object.ErrFileNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file. path: %s error: %v", path, err)
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file. path: %s error: %v", path, err)
+	}
+
+	return []byte(contents), nil
+}
+
 func (g *GitHub) Push(ctx context.Context, repo *git.Repository) error {
 	log.Info(ctx, "trying reposiotry push to origin...")
-	o := &git.PushOptions{
-		Auth: &http.BasicAuth{
-			Username: g.username,
-			Password: g.token,
-		},
-	}
+	return g.push(ctx, repo, &git.PushOptions{
+		Auth: g.auth(),
+	})
+}
+
+// ForcePush はbranchだけをoriginに強制的にpushします
+// 前回の更新で途中まで進んだブランチを今の内容で置き換えるときに使います
+func (g *GitHub) ForcePush(ctx context.Context, repo *git.Repository, branch string) error {
+	log.Info(ctx, "trying force push to origin. branch: %s", branch)
+	return g.push(ctx, repo, &git.PushOptions{
+		RefSpecs: []config.RefSpec{config.RefSpec(fmt.Sprintf("+refs/heads/%s:refs/heads/%s", branch, branch))},
+		Auth:     g.auth(),
+	})
+}
+
+func (g *GitHub) push(ctx context.Context, repo *git.Repository, o *git.PushOptions) error {
 	log.Info(ctx, "push options: %v", o)
 	if err := o.Validate(); err != nil {
 		log.Error(ctx, "failed to validate push options. error: %v", err)
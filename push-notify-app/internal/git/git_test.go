@@ -0,0 +1,143 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+func TestForcePushReplacesStrandedBranch(t *testing.T) {
+	ctx := context.Background()
+	branch := "image_updater_app_dev_v2"
+
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, upstream, upstreamDir, "kustomization.yaml", "images: []\n")
+
+	// 前回の更新でpushだけされたブランチ
+	strandedDir := t.TempDir()
+	stranded, err := git.PlainClone(strandedDir, false, &git.CloneOptions{URL: upstreamDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &GitHub{}
+	if err := g.Branch(ctx, stranded, branch); err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, stranded, strandedDir, "kustomization.yaml", "images: [app:v1]\n")
+	if err := g.Push(ctx, stranded); err != nil {
+		t.Fatal(err)
+	}
+
+	// 今回の更新は同じブランチ名で別のコミットになる
+	dir := t.TempDir()
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{URL: upstreamDir, SingleBranch: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	workspace, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := workspace.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Create: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := commitFile(t, repo, dir, "kustomization.yaml", "images: [app:v2]\n")
+
+	if err := g.Push(ctx, repo); err == nil {
+		t.Fatal("Push() error = nil, want non fast forward")
+	}
+	if err := g.ForcePush(ctx, repo, branch); err != nil {
+		t.Fatalf("ForcePush() error = %v", err)
+	}
+
+	got, err := upstream.Reference(plumbing.NewBranchReferenceName(branch), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Hash() != want {
+		t.Errorf("upstream %s = %s, want %s", branch, got.Hash(), want)
+	}
+}
+
+func TestSparseCloneCommitKeepsOtherFiles(t *testing.T) {
+	ctx := context.Background()
+
+	// Cloneはrepositoryの5番目の要素をディレクトリ名に使うため、URLと同じ深さにする
+	upstreamDir := filepath.Join(t.TempDir(), "owner", "manifests")
+	if err := os.MkdirAll(filepath.Join(upstreamDir, "overlays", "dev"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(upstreamDir, "overlays", "prod"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitFile(t, upstream, upstreamDir, "overlays/prod/kustomization.yaml", "images: [app:v1]\n")
+	commitFile(t, upstream, upstreamDir, "overlays/dev/kustomization.yaml", "images: [app:v1]\n")
+
+	g := &GitHub{}
+	repo, dir, release, err := g.Clone(ctx, upstreamDir, CloneOptions{SparseDirectories: []string{"overlays/dev"}})
+	if err != nil {
+		t.Fatalf("Clone() error = %v", err)
+	}
+	defer release()
+
+	if _, err := os.Stat(filepath.Join(dir, "overlays", "prod", "kustomization.yaml")); !os.IsNotExist(err) {
+		t.Fatalf("file outside sparse dirs is checked out. error: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "overlays", "dev", "kustomization.yaml"), []byte("images: [app:v2]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	workspace, err := repo.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := workspace.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	visible := visibleStatus(repo, status)
+	if _, ok := visible["overlays/dev/kustomization.yaml"]; len(visible) != 1 || !ok {
+		t.Errorf("status = %v, want only overlays/dev/kustomization.yaml", visible)
+	}
+
+	hash, err := g.Commit(ctx, repo, []string{"overlays/dev/kustomization.yaml"}, "update dev")
+	if err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"overlays/dev/kustomization.yaml":  "images: [app:v2]\n",
+		"overlays/prod/kustomization.yaml": "images: [app:v1]\n",
+	}
+	for path, content := range want {
+		file, err := commit.File(path)
+		if err != nil {
+			t.Fatalf("commit has no %s. error: %v", path, err)
+		}
+		got, err := file.Contents()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != content {
+			t.Errorf("%s = %q, want %q", path, got, content)
+		}
+	}
+}
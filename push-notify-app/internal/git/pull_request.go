@@ -0,0 +1,72 @@
+package git
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v63/github"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+)
+
+type PullRequest struct {
+	Owner      string
+	Repository string
+	Head       string
+	Title      string
+	Body       string
+}
+
+// CreatePullRequest はデフォルトブランチに向けたPRを作成し、そのURLを返します
+// 同じブランチから既にPRが出ている場合はそのURLを返します
+func (g *GitHub) CreatePullRequest(ctx context.Context, pr *PullRequest) (string, error) {
+	repository, _, err := g.clinet.Repositories.Get(ctx, pr.Owner, pr.Repository)
+	if err != nil {
+		log.Error(ctx, "failed to get repository. repository: %s/%s error: %v", pr.Owner, pr.Repository, err)
+		return "", err
+	}
+
+	base := repository.GetDefaultBranch()
+
+	existing, err := g.FindPullRequest(ctx, pr.Owner, pr.Repository, pr.Head)
+	if err != nil {
+		return "", err
+	}
+
+	if existing != "" {
+		log.Info(ctx, "pull request already exists. url: %s", existing)
+		return existing, nil
+	}
+
+	log.Info(ctx, "trying create pull request. head: %s base: %s", pr.Head, base)
+	pull, _, err := g.clinet.PullRequests.Create(ctx, pr.Owner, pr.Repository, &github.NewPullRequest{
+		Title: github.String(pr.Title),
+		Head:  github.String(pr.Head),
+		Base:  github.String(base),
+		Body:  github.String(pr.Body),
+	})
+	if err != nil {
+		log.Error(ctx, "failed to create pull request. error: %v", err)
+		return "", err
+	}
+
+	log.Info(ctx, "pull request created successfuly. url: %s", pull.GetHTMLURL())
+	return pull.GetHTMLURL(), nil
+}
+
+// FindPullRequest はheadブランチから出ているopenなPRのURLを返します。ない場合は空文字を返します
+func (g *GitHub) FindPullRequest(ctx context.Context, owner, repository, head string) (string, error) {
+	pulls, _, err := g.clinet.PullRequests.List(ctx, owner, repository, &github.PullRequestListOptions{
+		State: "open",
+		Head:  fmt.Sprintf("%s:%s", owner, head),
+	})
+	if err != nil {
+		log.Error(ctx, "failed to list pull requests. error: %v", err)
+		return "", err
+	}
+
+	if len(pulls) == 0 {
+		return "", nil
+	}
+
+	return pulls[0].GetHTMLURL(), nil
+}
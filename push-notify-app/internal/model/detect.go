@@ -0,0 +1,63 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownEventFormat はどのregistryのイベントか判別できない場合のエラーです
+var ErrUnknownEventFormat = errors.New("unknown event format")
+
+// ParseImageEvents はregistryのイベントのJSONを判別してImageEventに変換します
+// ECR(EventBridge), registry:2, Harbor, GHCRのイベントと、ImageEventそのものに対応します
+// pushでないイベントやタグのないイベントの場合は空を返します
+func ParseImageEvents(data []byte) ([]*ImageEvent, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal event. error: %v", err)
+	}
+
+	has := func(key string) bool {
+		_, ok := keys[key]
+		return ok
+	}
+
+	switch {
+	case has("detail-type") && has("detail"):
+		var event ECRPushEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ecr event. error: %v", err)
+		}
+		if event.Detail.ActionType != ECRAcTionPush {
+			return nil, nil
+		}
+		return []*ImageEvent{event.ToImageEvent()}, nil
+	case has("events"):
+		var envelope DistributionEnvelope
+		if err := json.Unmarshal(data, &envelope); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal distribution event. error: %v", err)
+		}
+		return envelope.ToImageEvents(), nil
+	case has("event_data"):
+		var event HarborEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal harbor event. error: %v", err)
+		}
+		return event.ToImageEvents(), nil
+	case has("registry_package") || has("package"):
+		var event GitHubPackageEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ghcr event. error: %v", err)
+		}
+		return event.ToImageEvents(), nil
+	case has("registry") && has("repository"):
+		var event ImageEvent
+		if err := json.Unmarshal(data, &event); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal image event. error: %v", err)
+		}
+		return []*ImageEvent{&event}, nil
+	}
+
+	return nil, ErrUnknownEventFormat
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DistributionEnvelope はDocker Distribution(registry:2)のnotificationで送られるenvelopeです
+// https://distribution.github.io/distribution/about/notifications/
+type DistributionEnvelope struct {
+	Events []DistributionEvent `json:"events"`
+}
+
+type DistributionAction string
+
+const (
+	DistributionActionPush DistributionAction = "push"
+)
+
+type DistributionEvent struct {
+	ID        string              `json:"id"`
+	Timestamp string              `json:"timestamp"`
+	Action    DistributionAction  `json:"action"`
+	Target    DistributionTarget  `json:"target"`
+	Request   DistributionRequest `json:"request"`
+}
+
+type DistributionTarget struct {
+	MediaType  string `json:"mediaType"`
+	Digest     string `json:"digest"`
+	Repository string `json:"repository"`
+	URL        string `json:"url"`
+	Tag        string `json:"tag"`
+}
+
+type DistributionRequest struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+	Host string `json:"host"`
+}
+
+// ToImageEvents はタグ付きのpushイベントだけをImageEventに変換します
+// accountの代わりにregistryのhostを使うため、RegistryConfig.Envはhostをキーにします
+func (e *DistributionEnvelope) ToImageEvents() []*ImageEvent {
+	var events []*ImageEvent
+	for _, event := range e.Events {
+		if event.Action != DistributionActionPush || event.Target.Tag == "" {
+			continue
+		}
+
+		host := event.registryHost()
+		events = append(events, &ImageEvent{
+			Registry:   host,
+			Repository: event.Target.Repository,
+			Tag:        event.Target.Tag,
+			Digest:     event.Target.Digest,
+			MediaType:  event.Target.MediaType,
+			Source: SourceMetadata{
+				Type:    SourceDistribution,
+				ID:      event.ID,
+				Time:    event.Timestamp,
+				Account: host,
+			},
+		})
+	}
+
+	return events
+}
+
+// registryHost はpushされたregistryのhostを返します
+// クライアントが接続したhostを優先し、取れない場合はtargetのURLから取得します
+func (e *DistributionEvent) registryHost() string {
+	if e.Request.Host != "" {
+		return e.Request.Host
+	}
+
+	u, err := url.Parse(e.Target.URL)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(u.Host, "/")
+}
@@ -1,5 +1,7 @@
 package model
 
+import "fmt"
+
 type ECRPushEvent struct {
 	Account    string   `json:"account"`
 	Detail     Detail   `json:"detail"`
@@ -12,6 +14,24 @@ type ECRPushEvent struct {
 	Version    string   `json:"version"`
 }
 
+// ToImageEvent はECRのイベントをImageEventに変換します
+func (e *ECRPushEvent) ToImageEvent() *ImageEvent {
+	return &ImageEvent{
+		Registry:   fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", e.Account, e.Region),
+		Repository: e.Detail.RepositoryName,
+		Tag:        e.Detail.ImageTag,
+		Digest:     e.Detail.ImageDigest,
+		MediaType:  e.Detail.ManifestMediaType,
+		Source: SourceMetadata{
+			Type:    SourceECR,
+			ID:      e.ID,
+			Time:    e.Time,
+			Account: e.Account,
+			Region:  e.Region,
+		},
+	}
+}
+
 type ECRActionType string
 
 const (
@@ -0,0 +1,101 @@
+package model
+
+import (
+	"strings"
+)
+
+// GitHubPackageEvent はGitHubのregistry_package(package)webhookで送られるイベントです
+// https://docs.github.com/en/webhooks/webhook-events-and-payloads#registry_package
+type GitHubPackageEvent struct {
+	Action          GitHubPackageAction `json:"action"`
+	RegistryPackage *GitHubPackage      `json:"registry_package"`
+	// Package はpackageイベントの場合に入ります
+	Package *GitHubPackage `json:"package"`
+}
+
+type GitHubPackageAction string
+
+const (
+	GitHubPackageActionPublished GitHubPackageAction = "published"
+)
+
+type GitHubPackage struct {
+	Name           string                `json:"name"`
+	Namespace      string                `json:"namespace"`
+	PackageType    string                `json:"package_type"`
+	Owner          GitHubPackageOwner    `json:"owner"`
+	PackageVersion GitHubPackageVersion  `json:"package_version"`
+	Registry       GitHubPackageRegistry `json:"registry"`
+}
+
+type GitHubPackageOwner struct {
+	Login string `json:"login"`
+}
+
+type GitHubPackageVersion struct {
+	// e.g. sha256:...
+	Version           string                  `json:"version"`
+	ContainerMetadata GitHubContainerMetadata `json:"container_metadata"`
+	// e.g. ghcr.io/murasame29/sample-app:v1.0.0
+	PackageURL string `json:"package_url"`
+}
+
+type GitHubContainerMetadata struct {
+	Tag GitHubContainerTag `json:"tag"`
+}
+
+type GitHubContainerTag struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+}
+
+type GitHubPackageRegistry struct {
+	Host string `json:"host"`
+	URL  string `json:"url"`
+}
+
+const ghcrHost = "ghcr.io"
+
+// ToImageEvents はタグ付きのコンテナpublishイベントをImageEventに変換します
+// accountの代わりにpackageのownerを使うため、RegistryConfig.Envはownerかhostをキーにします
+func (e *GitHubPackageEvent) ToImageEvents() []*ImageEvent {
+	pkg := e.RegistryPackage
+	if pkg == nil {
+		pkg = e.Package
+	}
+
+	if pkg == nil || e.Action != GitHubPackageActionPublished || !strings.EqualFold(pkg.PackageType, "container") {
+		return nil
+	}
+
+	tag := pkg.PackageVersion.ContainerMetadata.Tag
+	if tag.Name == "" {
+		return nil
+	}
+
+	digest := tag.Digest
+	if digest == "" {
+		digest = pkg.PackageVersion.Version
+	}
+
+	host := pkg.Registry.Host
+	if host == "" {
+		host = ghcrHost
+	}
+
+	owner := strings.ToLower(pkg.Owner.Login)
+
+	return []*ImageEvent{
+		{
+			Registry:   host,
+			Repository: strings.ToLower(owner + "/" + pkg.Name),
+			Tag:        tag.Name,
+			Digest:     digest,
+			Source: SourceMetadata{
+				Type:    SourceGHCR,
+				ID:      digest,
+				Account: owner,
+			},
+		},
+	}
+}
@@ -0,0 +1,72 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HarborEvent はHarborのwebhookで送られるイベントです
+// https://goharbor.io/docs/main/working-with-projects/project-configuration/configure-webhooks/
+type HarborEvent struct {
+	Type      HarborEventType `json:"type"`
+	OccurAt   int64           `json:"occur_at"`
+	Operator  string          `json:"operator"`
+	EventData HarborEventData `json:"event_data"`
+}
+
+type HarborEventType string
+
+const (
+	HarborEventPushArtifact HarborEventType = "PUSH_ARTIFACT"
+)
+
+type HarborEventData struct {
+	Resources  []HarborResource `json:"resources"`
+	Repository HarborRepository `json:"repository"`
+}
+
+type HarborResource struct {
+	Digest string `json:"digest"`
+	Tag    string `json:"tag"`
+	// e.g. harbor.example.com/library/app:v1.0.0
+	ResourceURL string `json:"resource_url"`
+}
+
+type HarborRepository struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// e.g. library/app
+	RepoFullName string `json:"repo_full_name"`
+	RepoType     string `json:"repo_type"`
+}
+
+// ToImageEvents はタグ付きのPUSH_ARTIFACTをImageEventに変換します
+// accountの代わりにHarborのproject名を使うため、RegistryConfig.Envはprojectかhostをキーにします
+func (e *HarborEvent) ToImageEvents() []*ImageEvent {
+	if e.Type != HarborEventPushArtifact {
+		return nil
+	}
+
+	var events []*ImageEvent
+	for _, resource := range e.EventData.Resources {
+		if resource.Tag == "" {
+			continue
+		}
+
+		host, _, _ := strings.Cut(resource.ResourceURL, "/")
+		events = append(events, &ImageEvent{
+			Registry:   host,
+			Repository: e.EventData.Repository.RepoFullName,
+			Tag:        resource.Tag,
+			Digest:     resource.Digest,
+			Source: SourceMetadata{
+				Type:    SourceHarbor,
+				ID:      fmt.Sprintf("%s@%s", e.EventData.Repository.RepoFullName, resource.Digest),
+				Time:    fmt.Sprintf("%d", e.OccurAt),
+				Account: e.EventData.Repository.Namespace,
+			},
+		})
+	}
+
+	return events
+}
@@ -0,0 +1,55 @@
+package model
+
+import "fmt"
+
+// ImageEvent はregistryに依存しないイメージのpushイベントです
+// 各イベントソースはこの形に変換してからupdaterに渡します
+type ImageEvent struct {
+	// e.g. 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com
+	// e.g. ghcr.io
+	Registry string `json:"registry"`
+	// e.g. example/sample/sample-app/app
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
+	Digest     string `json:"digest"`
+	MediaType  string `json:"mediaType"`
+	// Environment を指定した場合はRegistryConfig.Envを引かずにこの環境を更新します
+	// 次の環境への昇格(promotion)で使います
+	// e.g. staging
+	Environment string `json:"environment,omitempty"`
+
+	Source SourceMetadata `json:"source"`
+}
+
+type SourceType string
+
+const (
+	SourceECR          SourceType = "ecr"
+	SourceDistribution SourceType = "distribution"
+	SourceHarbor       SourceType = "harbor"
+	SourceGHCR         SourceType = "ghcr"
+	SourcePoll         SourceType = "poll"
+	SourcePromotion    SourceType = "promotion"
+)
+
+// SourceMetadata はイベントの発生元の情報です
+type SourceMetadata struct {
+	Type SourceType `json:"type"`
+	ID   string     `json:"id"`
+	Time string     `json:"time"`
+	// Account はRegistryConfig.Envを引くためのキーです
+	// ECRはaccount ID、Harborはproject、GHCRはowner、registry:2とpollはhostが入ります
+	Account string `json:"account"`
+	// Region はECRの場合のみ入ります
+	Region string `json:"region"`
+}
+
+// Reference はタグを含まないイメージの参照を返します
+// e.g. 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/sample/sample-app/app
+func (e *ImageEvent) Reference() string {
+	return fmt.Sprintf("%s/%s", e.Registry, e.Repository)
+}
+
+func (e *ImageEvent) String() string {
+	return fmt.Sprintf("%s:%s (digest: %s, source: %s)", e.Reference(), e.Tag, e.Digest, e.Source.Type)
+}
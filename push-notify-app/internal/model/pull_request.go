@@ -0,0 +1,47 @@
+package model
+
+// GitHubPullRequestEvent はGitHubのpull_request webhookで送られるイベントです
+// https://docs.github.com/en/webhooks/webhook-events-and-payloads#pull_request
+type GitHubPullRequestEvent struct {
+	Action      GitHubPullRequestAction `json:"action"`
+	PullRequest GitHubPullRequest       `json:"pull_request"`
+	// Label はlabeledの場合に付けられたラベルが入ります
+	Label *GitHubLabel `json:"label"`
+}
+
+type GitHubPullRequestAction string
+
+const (
+	GitHubPullRequestActionClosed  GitHubPullRequestAction = "closed"
+	GitHubPullRequestActionLabeled GitHubPullRequestAction = "labeled"
+)
+
+type GitHubPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	Body    string `json:"body"`
+	Merged  bool   `json:"merged"`
+	// e.g. 2024-08-01T00:00:00Z
+	MergedAt string                `json:"merged_at"`
+	Labels   []GitHubLabel         `json:"labels"`
+	Head     GitHubPullRequestHead `json:"head"`
+}
+
+type GitHubPullRequestHead struct {
+	// e.g. image_updater_sample-app_dev_v1
+	Ref string `json:"ref"`
+}
+
+type GitHubLabel struct {
+	Name string `json:"name"`
+}
+
+// HasLabel はPRにラベルが付いているかを返します
+func (pr *GitHubPullRequest) HasLabel(name string) bool {
+	for _, label := range pr.Labels {
+		if label.Name == name {
+			return true
+		}
+	}
+	return false
+}
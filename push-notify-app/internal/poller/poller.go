@@ -0,0 +1,178 @@
+package poller
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/registry"
+)
+
+// HandleFunc は検知したイベントを処理に回す関数です
+// 更新処理が終わったらdoneを呼びます。doneにエラーを渡した場合は次回のpollで再検知します
+type HandleFunc func(ctx context.Context, event *model.ImageEvent, done func(err error)) error
+
+// Target はpollするrepositoryです
+type Target struct {
+	// e.g. registry.example.com/team/app
+	Repository string
+	// MutableTags はdigestが変わったかを毎回確認するタグです
+	// e.g. stable
+	MutableTags []string
+}
+
+// Poller はイベントを送れないregistryのタグを定期的に取得し、新しいタグを検知します
+type Poller struct {
+	client   *registry.Client
+	state    *State
+	interval time.Duration
+	handle   HandleFunc
+
+	mu sync.Mutex
+	// pending は更新処理に回して終わっていないタグです
+	// e.g. registry.example.com/team/app:v1.0.0
+	pending map[string]bool
+}
+
+func NewPoller(client *registry.Client, state *State, interval time.Duration, handle HandleFunc) *Poller {
+	return &Poller{
+		client:   client,
+		state:    state,
+		interval: interval,
+		handle:   handle,
+		pending:  make(map[string]bool),
+	}
+}
+
+// Run はctxがキャンセルされるまでintervalごとにtargetsをpollします
+// targetsは設定の再読み込みに追従できるよう毎回呼び出します
+func (p *Poller) Run(ctx context.Context, targets func() []Target) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.pollAll(ctx, targets())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Poller) pollAll(ctx context.Context, targets []Target) {
+	for _, target := range targets {
+		if err := p.poll(ctx, target); err != nil {
+			log.Error(ctx, "failed to poll repository. repository: %s error: %v", target.Repository, err)
+		}
+	}
+
+	if err := p.state.Save(); err != nil {
+		log.Error(ctx, "failed to save poll state. error: %v", err)
+	}
+}
+
+func (p *Poller) poll(ctx context.Context, target Target) error {
+	host, repository, _ := strings.Cut(target.Repository, "/")
+
+	log.Trace(ctx, "trying list tags. repository: %s", target.Repository)
+	tags, err := p.client.ListTags(ctx, host, repository)
+	if err != nil {
+		return err
+	}
+
+	known, polled := p.state.Tags(target.Repository)
+	// recorded は検知済みとして記録するタグです
+	// 更新処理に回したタグは、更新が終わってから記録します
+	recorded := make(map[string]string, len(tags))
+
+	for _, tag := range tags {
+		digest, seen := known[tag]
+		if seen && !slices.Contains(target.MutableTags, tag) {
+			continue
+		}
+
+		// 更新処理が終わっていないタグは再検知しない
+		if p.isPending(target.Repository, tag) {
+			continue
+		}
+
+		newDigest, err := p.client.Digest(ctx, host, repository, tag)
+		if err != nil {
+			log.Warn(ctx, "failed to get digest. repository: %s tag: %s error: %v", target.Repository, tag, err)
+			continue
+		}
+
+		// 初回は既存のタグを記録するだけで更新はしない
+		if !polled || digest == newDigest {
+			recorded[tag] = newDigest
+			continue
+		}
+
+		log.Info(ctx, "new image detected. repository: %s tag: %s digest: %s", target.Repository, tag, newDigest)
+		p.dispatch(ctx, target.Repository, &model.ImageEvent{
+			Registry:   host,
+			Repository: repository,
+			Tag:        tag,
+			Digest:     newDigest,
+			Source: model.SourceMetadata{
+				Type:    model.SourcePoll,
+				ID:      newDigest,
+				Time:    time.Now().Format(time.RFC3339),
+				Account: host,
+			},
+		})
+	}
+
+	if !polled {
+		log.Info(ctx, "poll state initialized. repository: %s tags: %d", target.Repository, len(recorded))
+	}
+
+	p.state.UpdateTags(target.Repository, tags, recorded)
+	return nil
+}
+
+// dispatch はイベントを更新処理に回し、更新が成功したらタグを記録します
+// 失敗した場合は記録しないため、次回のpollで再検知します
+func (p *Poller) dispatch(ctx context.Context, reference string, event *model.ImageEvent) {
+	key := reference + ":" + event.Tag
+
+	p.mu.Lock()
+	p.pending[key] = true
+	p.mu.Unlock()
+
+	done := func(err error) {
+		p.mu.Lock()
+		delete(p.pending, key)
+		p.mu.Unlock()
+
+		if err != nil {
+			log.Warn(ctx, "update failed. retry on next poll. repository: %s tag: %s error: %v", reference, event.Tag, err)
+			return
+		}
+
+		p.state.SetTag(reference, event.Tag, event.Digest)
+		if err := p.state.Save(); err != nil {
+			log.Error(ctx, "failed to save poll state. error: %v", err)
+		}
+	}
+
+	if err := p.handle(ctx, event, done); err != nil {
+		log.Error(ctx, "failed to handle event. repository: %s tag: %s error: %v", reference, event.Tag, err)
+		p.mu.Lock()
+		delete(p.pending, key)
+		p.mu.Unlock()
+	}
+}
+
+func (p *Poller) isPending(reference, tag string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.pending[reference+":"+tag]
+}
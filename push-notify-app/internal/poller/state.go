@@ -0,0 +1,129 @@
+package poller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// State は既に検知したタグとdigestを保持し、ファイルに永続化します
+// 再起動しても同じタグで更新処理が走らないようにするためのものです
+type State struct {
+	path string
+
+	// saveMu はpollと更新処理の完了から同時にSaveしても一時ファイルが壊れないよう直列化します
+	saveMu sync.Mutex
+
+	mu sync.Mutex
+	// repositories はイメージの参照ごとのタグとdigestの対応です
+	// e.g. registry.example.com/team/app: {v1.0.0: sha256:...}
+	repositories map[string]map[string]string
+}
+
+func LoadState(path string) (*State, error) {
+	state := &State{
+		path:         path,
+		repositories: make(map[string]map[string]string),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read state. error: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &state.repositories); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal state. error: %v", err)
+	}
+
+	return state, nil
+}
+
+// Tags はrepositoryの既知のタグを返します。一度もpollしていない場合はfalseを返します
+func (s *State) Tags(reference string) (map[string]string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags, ok := s.repositories[reference]
+	if !ok {
+		return nil, false
+	}
+
+	copied := make(map[string]string, len(tags))
+	for tag, digest := range tags {
+		copied[tag] = digest
+	}
+
+	return copied, true
+}
+
+// UpdateTags はpollの結果を記録します
+// listedにないタグは削除し、recordedのタグのdigestを記録します。それ以外のタグはそのまま残します
+func (s *State) UpdateTags(reference string, listed []string, recorded map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags, ok := s.repositories[reference]
+	if !ok {
+		tags = make(map[string]string, len(recorded))
+		s.repositories[reference] = tags
+	}
+
+	for tag := range tags {
+		if !slices.Contains(listed, tag) {
+			delete(tags, tag)
+		}
+	}
+
+	for tag, digest := range recorded {
+		tags[tag] = digest
+	}
+}
+
+// SetTag はタグのdigestを記録します。更新処理が終わったタグを記録するために使います
+func (s *State) SetTag(reference, tag, digest string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags, ok := s.repositories[reference]
+	if !ok {
+		tags = make(map[string]string)
+		s.repositories[reference] = tags
+	}
+
+	tags[tag] = digest
+}
+
+// Save は一時ファイルに書き出してからrenameし、書き込み途中の状態が残らないようにします
+func (s *State) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s.repositories, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal state. error: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir. error: %v", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write state. error: %v", err)
+	}
+
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to rename state. error: %v", err)
+	}
+
+	return nil
+}
@@ -5,15 +5,18 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/queue"
 )
 
+var _ queue.EventSource = (*SQS)(nil)
+
 type SQS struct {
 	queueURI string
 	arn      string
@@ -53,7 +56,7 @@ func NewSQS(queueURI, arn string) (*SQS, error) {
 	}, nil
 }
 
-func (s *SQS) ReceiveMessage(ctx context.Context) ([]types.Message, error) {
+func (s *SQS) Receive(ctx context.Context) ([]queue.Message, error) {
 	input := &sqs.ReceiveMessageInput{
 		QueueUrl:            &s.queueURI,
 		VisibilityTimeout:   20,
@@ -66,13 +69,22 @@ func (s *SQS) ReceiveMessage(ctx context.Context) ([]types.Message, error) {
 		return nil, err
 	}
 
-	return output.Messages, nil
+	messages := make([]queue.Message, 0, len(output.Messages))
+	for _, message := range output.Messages {
+		messages = append(messages, queue.Message{
+			ID:     aws.ToString(message.MessageId),
+			Body:   []byte(aws.ToString(message.Body)),
+			Handle: aws.ToString(message.ReceiptHandle),
+		})
+	}
+
+	return messages, nil
 }
 
-func (s *SQS) DeleteMessage(ctx context.Context, receiptHandle string) error {
+func (s *SQS) Ack(ctx context.Context, message queue.Message) error {
 	input := &sqs.DeleteMessageInput{
 		QueueUrl:      &s.queueURI,
-		ReceiptHandle: &receiptHandle,
+		ReceiptHandle: &message.Handle,
 	}
 
 	_, err := s.client.DeleteMessage(ctx, input)
@@ -82,3 +94,26 @@ func (s *SQS) DeleteMessage(ctx context.Context, receiptHandle string) error {
 
 	return nil
 }
+
+func (s *SQS) Nack(ctx context.Context, message queue.Message) error {
+	return s.changeVisibility(ctx, message, 0)
+}
+
+func (s *SQS) Extend(ctx context.Context, message queue.Message, timeout time.Duration) error {
+	return s.changeVisibility(ctx, message, timeout)
+}
+
+func (s *SQS) changeVisibility(ctx context.Context, message queue.Message, timeout time.Duration) error {
+	input := &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          &s.queueURI,
+		ReceiptHandle:     &message.Handle,
+		VisibilityTimeout: int32(timeout.Seconds()),
+	}
+
+	_, err := s.client.ChangeMessageVisibility(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
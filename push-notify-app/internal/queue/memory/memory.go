@@ -0,0 +1,164 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/queue"
+)
+
+var _ queue.EventSource = (*Memory)(nil)
+
+// Memory はメモリ上で動作するEventSourceです
+// AWSなどの外部サービスなしでパイプラインを動かす場合に使います
+// SQSと同じく、受信後にAckもNackもされないままvisibilityTimeoutを過ぎたメッセージは再配信します
+type Memory struct {
+	mu       sync.Mutex
+	messages []queue.Message
+	inflight map[string]inflightMessage
+	notify   chan struct{}
+	sequence int
+
+	waitTime          time.Duration
+	maxMessages       int
+	visibilityTimeout time.Duration
+}
+
+// inflightMessage は受信済みでAckされていないメッセージです
+type inflightMessage struct {
+	message queue.Message
+	// deadline を過ぎると再配信します
+	deadline time.Time
+}
+
+func NewMemory(waitTime time.Duration, maxMessages int, visibilityTimeout time.Duration) *Memory {
+	return &Memory{
+		inflight:          make(map[string]inflightMessage),
+		notify:            make(chan struct{}, 1),
+		waitTime:          waitTime,
+		maxMessages:       maxMessages,
+		visibilityTimeout: visibilityTimeout,
+	}
+}
+
+// Send はメッセージをキューに追加します
+func (m *Memory) Send(body []byte) string {
+	m.mu.Lock()
+	m.sequence++
+	id := fmt.Sprintf("%d", m.sequence)
+	m.messages = append(m.messages, queue.Message{
+		ID:     id,
+		Body:   body,
+		Handle: id,
+	})
+	m.mu.Unlock()
+
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+
+	return id
+}
+
+func (m *Memory) Receive(ctx context.Context) ([]queue.Message, error) {
+	if messages := m.take(); len(messages) != 0 {
+		return messages, nil
+	}
+
+	timer := time.NewTimer(m.waitTime)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timer.C:
+		return nil, nil
+	case <-m.notify:
+		return m.take(), nil
+	}
+}
+
+func (m *Memory) take() []queue.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.requeueExpired(now)
+
+	n := min(len(m.messages), m.maxMessages)
+	messages := make([]queue.Message, n)
+	copy(messages, m.messages[:n])
+	m.messages = m.messages[n:]
+
+	for _, message := range messages {
+		m.inflight[message.Handle] = inflightMessage{
+			message:  message,
+			deadline: now.Add(m.visibilityTimeout),
+		}
+	}
+
+	return messages
+}
+
+// requeueExpired は可視性タイムアウトを過ぎたメッセージをキューに戻します
+// 受信時に確認するため、Receiveで待っている間に期限が来たメッセージは次のReceiveで再配信されます
+func (m *Memory) requeueExpired(now time.Time) {
+	for handle, inflight := range m.inflight {
+		if now.Before(inflight.deadline) {
+			continue
+		}
+
+		delete(m.inflight, handle)
+		m.messages = append(m.messages, inflight.message)
+	}
+}
+
+func (m *Memory) Ack(ctx context.Context, message queue.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.inflight[message.Handle]; !ok {
+		return fmt.Errorf("message not in flight. id: %s", message.ID)
+	}
+
+	delete(m.inflight, message.Handle)
+	return nil
+}
+
+func (m *Memory) Nack(ctx context.Context, message queue.Message) error {
+	m.mu.Lock()
+	inflight, ok := m.inflight[message.Handle]
+	if !ok {
+		m.mu.Unlock()
+		return fmt.Errorf("message not in flight. id: %s", message.ID)
+	}
+
+	delete(m.inflight, message.Handle)
+	m.messages = append(m.messages, inflight.message)
+	m.mu.Unlock()
+
+	select {
+	case m.notify <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Extend は再配信されるまでの時間を今からtimeoutに延長します
+func (m *Memory) Extend(ctx context.Context, message queue.Message, timeout time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inflight, ok := m.inflight[message.Handle]
+	if !ok {
+		return fmt.Errorf("message not in flight. id: %s", message.ID)
+	}
+
+	inflight.deadline = time.Now().Add(timeout)
+	m.inflight[message.Handle] = inflight
+	return nil
+}
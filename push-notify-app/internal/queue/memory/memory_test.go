@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/queue"
+)
+
+const testVisibilityTimeout = 50 * time.Millisecond
+
+func TestVisibilityTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// after は受信したメッセージに対して行う操作です
+		after      func(ctx context.Context, m *Memory, message queue.Message) error
+		wantResend bool
+	}{
+		{
+			name:       "AckもNackもしない場合は可視性タイムアウト後に再配信する",
+			wantResend: true,
+		},
+		{
+			name: "Ackした場合は再配信しない",
+			after: func(ctx context.Context, m *Memory, message queue.Message) error {
+				return m.Ack(ctx, message)
+			},
+		},
+		{
+			name: "Extendした場合は延長した時間まで再配信しない",
+			after: func(ctx context.Context, m *Memory, message queue.Message) error {
+				return m.Extend(ctx, message, time.Minute)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := NewMemory(10*time.Millisecond, 10, testVisibilityTimeout)
+			id := m.Send([]byte("event"))
+
+			messages, err := m.Receive(ctx)
+			if err != nil {
+				t.Fatalf("Receive() error = %v", err)
+			}
+			if len(messages) != 1 || messages[0].ID != id {
+				t.Fatalf("messages = %v, want %s", messages, id)
+			}
+
+			if tt.after != nil {
+				if err := tt.after(ctx, m, messages[0]); err != nil {
+					t.Fatalf("after() error = %v", err)
+				}
+			}
+
+			// 可視性タイムアウト中は再配信しない
+			if messages, _ := m.Receive(ctx); len(messages) != 0 {
+				t.Fatalf("messages = %v, want none before timeout", messages)
+			}
+
+			time.Sleep(testVisibilityTimeout)
+
+			resent, err := m.Receive(ctx)
+			if err != nil {
+				t.Fatalf("Receive() error = %v", err)
+			}
+			if gotResend := len(resent) == 1 && resent[0].ID == id; gotResend != tt.wantResend {
+				t.Errorf("resent = %v, want resend %v", resent, tt.wantResend)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package queue
+
+import (
+	"context"
+	"time"
+)
+
+// Message はイベントソースから受信したメッセージです
+type Message struct {
+	ID   string
+	Body []byte
+
+	// Handle はAck/Nack/Extendでメッセージを特定するためのソース固有の値です
+	// e.g. SQSのReceiptHandle
+	Handle string
+}
+
+// EventSource はイベントの受信元を抽象化したものです
+type EventSource interface {
+	// Receive はメッセージを受信します。メッセージがない場合は空のスライスを返します
+	Receive(ctx context.Context) ([]Message, error)
+	// Ack は処理が完了したメッセージをソースから削除します
+	Ack(ctx context.Context, message Message) error
+	// Nack はメッセージを即座に再配信できる状態に戻します
+	Nack(ctx context.Context, message Message) error
+	// Extend はメッセージが再配信されるまでの時間を延長します
+	Extend(ctx context.Context, message Message, timeout time.Duration) error
+}
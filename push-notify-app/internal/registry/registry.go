@@ -0,0 +1,311 @@
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// manifestAccept はHEAD /v2/<name>/manifests/<tag> で受け付けるmanifestの種類です
+var manifestAccept = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Credential はregistryの認証情報です
+type Credential struct {
+	Username string
+	Password string
+}
+
+// Client はOCI distribution APIのクライアントです
+// https://github.com/opencontainers/distribution-spec/blob/main/spec.md
+type Client struct {
+	// credentials はregistryのhostごとの認証情報です
+	// 他のregistryに認証情報を送らないよう、challengeを返したregistryのhostで引きます
+	credentials map[string]Credential
+
+	client *http.Client
+
+	mu sync.Mutex
+	// authorizations はhostとscopeごとのAuthorizationヘッダの値です
+	// e.g. registry.example.com repository:team/app:pull
+	authorizations map[string]string
+}
+
+func NewClient(credentials map[string]Credential) *Client {
+	return &Client{
+		credentials:    credentials,
+		client:         &http.Client{Timeout: 30 * time.Second},
+		authorizations: make(map[string]string),
+	}
+}
+
+type tagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// ListTags はrepositoryのタグ一覧を返します
+// e.g. host: registry.example.com repository: team/app
+func (c *Client) ListTags(ctx context.Context, host, repository string) ([]string, error) {
+	var tags []string
+
+	next := fmt.Sprintf("%s/v2/%s/tags/list", baseURL(host), repository)
+	for next != "" {
+		resp, err := c.do(ctx, http.MethodGet, next, host, repository, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var list tagList
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tag list. error: %v", err)
+		}
+
+		tags = append(tags, list.Tags...)
+
+		next, err = nextLink(next, resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+// Digest はタグが指すmanifestのdigestを返します
+func (c *Client) Digest(ctx context.Context, host, repository, tag string) (string, error) {
+	resp, err := c.do(ctx, http.MethodHead, fmt.Sprintf("%s/v2/%s/manifests/%s", baseURL(host), repository, tag), host, repository, http.Header{
+		"Accept": manifestAccept,
+	})
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("digest not found in response. repository: %s tag: %s", repository, tag)
+	}
+
+	return digest, nil
+}
+
+// do はリクエストを送り、401が返った場合はWWW-Authenticateに従って認証してから再送します
+func (c *Client) do(ctx context.Context, method, uri, host, repository string, header http.Header) (*http.Response, error) {
+	scope := fmt.Sprintf("repository:%s:pull", repository)
+
+	resp, err := c.send(ctx, method, uri, header, c.authorization(host, scope))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		authorization, err := c.authenticate(ctx, host, challenge, scope)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = c.send(ctx, method, uri, header, authorization)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w. %s %s status: %d", ErrUnauthorized, method, uri, resp.StatusCode)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected status. %s %s status: %d", method, uri, resp.StatusCode)
+	}
+
+	return resp, nil
+}
+
+func (c *Client) send(ctx context.Context, method, uri string, header http.Header, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	return c.client.Do(req)
+}
+
+func (c *Client) authorization(host, scope string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.authorizations[authorizationKey(host, scope)]
+}
+
+func authorizationKey(host, scope string) string {
+	return host + " " + scope
+}
+
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+}
+
+// authenticate はWWW-Authenticateヘッダのchallengeに応じたAuthorizationヘッダの値を返します
+// e.g. Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/ubuntu:pull"
+func (c *Client) authenticate(ctx context.Context, host, challenge, scope string) (string, error) {
+	scheme, params := parseChallenge(challenge)
+	credential, hasCredential := c.credentials[host]
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if !hasCredential {
+			return "", fmt.Errorf("%w. basic auth required but no credentials. host: %s", ErrUnauthorized, host)
+		}
+
+		authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(credential.Username+":"+credential.Password))
+		c.storeAuthorization(host, scope, authorization)
+		return authorization, nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("%w. unsupported challenge: %s", ErrUnauthorized, challenge)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid realm in challenge: %s", challenge)
+	}
+
+	requestScope := scope
+	if params["scope"] != "" {
+		requestScope = params["scope"]
+	}
+
+	query := realm.Query()
+	if params["service"] != "" {
+		query.Set("service", params["service"])
+	}
+	query.Set("scope", requestScope)
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+
+	if hasCredential {
+		req.SetBasicAuth(credential.Username, credential.Password)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to request token. error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("%w. token request failed. status: %d body: %s", ErrUnauthorized, resp.StatusCode, body)
+	}
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode token. error: %v", err)
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+
+	authorization := "Bearer " + token.Token
+	c.storeAuthorization(host, scope, authorization)
+	return authorization, nil
+}
+
+func (c *Client) storeAuthorization(host, scope, authorization string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.authorizations[authorizationKey(host, scope)] = authorization
+}
+
+// parseChallenge はWWW-Authenticateヘッダをschemeとパラメータに分割します
+func parseChallenge(challenge string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(challenge), " ")
+	params := make(map[string]string)
+
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+
+	return scheme, params
+}
+
+// nextLink はLinkヘッダから次のページのURLを返します
+// e.g. </v2/team/app/tags/list?n=100&last=v1.0.0>; rel="next"
+func nextLink(current, link string) (string, error) {
+	if link == "" {
+		return "", nil
+	}
+
+	target, _, _ := strings.Cut(link, ";")
+	target = strings.Trim(strings.TrimSpace(target), "<>")
+
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+
+	next, err := base.Parse(target)
+	if err != nil {
+		return "", err
+	}
+
+	return next.String(), nil
+}
+
+// baseURL はhostに対応するURLを返します
+// localhostの場合のみhttpで接続します
+func baseURL(host string) string {
+	if strings.HasPrefix(host, "localhost") || strings.HasPrefix(host, "127.0.0.1") {
+		return "http://" + host
+	}
+
+	return "https://" + host
+}
@@ -0,0 +1,98 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testRegistry はbearer tokenで認証するregistryです
+type testRegistry struct {
+	*httptest.Server
+	token string
+
+	mu             sync.Mutex
+	authorizations []string
+	tokenRequests  []string
+}
+
+func newTestRegistry(t *testing.T, token string) *testRegistry {
+	r := &testRegistry{token: token}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if req.URL.Path == "/token" {
+			username, _, _ := req.BasicAuth()
+			r.tokenRequests = append(r.tokenRequests, username)
+			json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+			return
+		}
+
+		authorization := req.Header.Get("Authorization")
+		r.authorizations = append(r.authorizations, authorization)
+		if authorization != "Bearer "+r.token {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, r.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"name": "team/app", "tags": []string{"v1"}})
+	}))
+	t.Cleanup(r.Close)
+
+	return r
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+func TestClientScopesAuthorizationByHost(t *testing.T) {
+	ctx := context.Background()
+	a := newTestRegistry(t, "token-a")
+	b := newTestRegistry(t, "token-b")
+
+	client := NewClient(map[string]Credential{
+		a.host(): {Username: "user-a", Password: "password-a"},
+	})
+
+	for _, r := range []*testRegistry{a, b} {
+		tags, err := client.ListTags(ctx, r.host(), "team/app")
+		if err != nil {
+			t.Fatalf("ListTags(%s) error = %v", r.host(), err)
+		}
+		if len(tags) != 1 || tags[0] != "v1" {
+			t.Errorf("tags = %v", tags)
+		}
+	}
+
+	// 同じrepositoryでもAのtokenをBに送らない
+	for _, authorization := range b.authorizations {
+		if authorization == "Bearer token-a" {
+			t.Errorf("token for %s was sent to %s", a.host(), b.host())
+		}
+	}
+
+	// 認証情報はAにだけ送る
+	if len(a.tokenRequests) != 1 || a.tokenRequests[0] != "user-a" {
+		t.Errorf("token requests to a = %v", a.tokenRequests)
+	}
+	if len(b.tokenRequests) != 1 || b.tokenRequests[0] != "" {
+		t.Errorf("token requests to b = %v", b.tokenRequests)
+	}
+
+	// 2回目はキャッシュしたtokenを使う
+	before := len(a.tokenRequests)
+	if _, err := client.ListTags(ctx, a.host(), "team/app"); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.tokenRequests) != before {
+		t.Errorf("token was requested again. requests: %v", a.tokenRequests)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+)
+
+const (
+	// HeaderSecret は共有シークレットをそのまま送る場合のヘッダです
+	// EventBridgeのconnectionではAPIキー認証としてこのヘッダを設定します
+	HeaderSecret = "X-Webhook-Secret"
+	// HeaderSignature はボディのHMAC-SHA256を送る場合のヘッダです
+	// e.g. sha256=5d41402abc4b2a76b9719d911017c592...
+	HeaderSignature = "X-Webhook-Signature-256"
+)
+
+// readVerifiedBody はボディを読み込み、シークレットを検証します
+// 検証に失敗した場合はレスポンスを書き込み、falseを返します
+func (s *Server) readVerifiedBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		log.Warn(s.ctx, "failed to read body. error: %v", err)
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if !s.verify(r.Header, body) {
+		log.Warn(s.ctx, "webhook verification failed. path: %s remote: %s", r.URL.Path, r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return body, true
+}
+
+// verify は共有シークレットまたはHMAC署名のどちらかが正しいかを検証します
+// シークレットが設定されていない場合(WEBHOOK_INSECUREで明示的に許可した場合)は検証しません
+func (s *Server) verify(header http.Header, body []byte) bool {
+	if s.secret == "" {
+		return true
+	}
+
+	if secret := header.Get(HeaderSecret); secret != "" {
+		return subtle.ConstantTimeCompare([]byte(secret), []byte(s.secret)) == 1
+	}
+
+	if signature := header.Get(HeaderSignature); signature != "" {
+		return verifySignature(s.secret, strings.TrimPrefix(signature, "sha256="), body)
+	}
+
+	// Harborはwebhookに設定したAuth Headerをそのまま送ってくる
+	if authorization := header.Get("Authorization"); authorization != "" {
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		return subtle.ConstantTimeCompare([]byte(token), []byte(s.secret)) == 1
+	}
+
+	return false
+}
+
+// verifySignature はhex encodeされたHMAC-SHA256の署名を検証します
+func verifySignature(secret, signature string, body []byte) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(mac.Sum(nil), expected)
+}
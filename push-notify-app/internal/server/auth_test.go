@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+const testSecret = "s3cr3t"
+
+const ecrBody = `{
+  "account": "123456789012",
+  "detail": {"action-type": "PUSH", "image-tag": "v1", "repository-name": "example/app"},
+  "detail-type": "ECR Image Action",
+  "region": "ap-northeast-1"
+}`
+
+const ghcrBody = `{
+  "action": "published",
+  "registry_package": {
+    "name": "app",
+    "package_type": "CONTAINER",
+    "owner": {"login": "example"},
+    "package_version": {"container_metadata": {"tag": {"name": "v1", "digest": "sha256:0123"}}}
+  }
+}`
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newTestServer(secret string) (*Server, *[]*model.ImageEvent) {
+	var events []*model.ImageEvent
+	s := NewServer(context.Background(), ":0", secret, func(_ context.Context, event *model.ImageEvent) error {
+		events = append(events, event)
+		return nil
+	})
+
+	return s, &events
+}
+
+func TestVerify(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		header   map[string]string
+		wantCode int
+	}{
+		{
+			name:     "共有シークレットが正しい",
+			secret:   testSecret,
+			header:   map[string]string{HeaderSecret: testSecret},
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "共有シークレットが違う",
+			secret:   testSecret,
+			header:   map[string]string{HeaderSecret: "wrong"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "シークレットがない",
+			secret:   testSecret,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "HMAC署名が正しい",
+			secret:   testSecret,
+			header:   map[string]string{HeaderSignature: sign(testSecret, ecrBody)},
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "HMAC署名が違う",
+			secret:   testSecret,
+			header:   map[string]string{HeaderSignature: sign("wrong", ecrBody)},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "HMAC署名がhexでない",
+			secret:   testSecret,
+			header:   map[string]string{HeaderSignature: "sha256=zz"},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "Authorizationヘッダが正しい",
+			secret:   testSecret,
+			header:   map[string]string{"Authorization": "Bearer " + testSecret},
+			wantCode: http.StatusAccepted,
+		},
+		{
+			name:     "シークレットを設定していない場合は検証しない",
+			secret:   "",
+			wantCode: http.StatusAccepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, events := newTestServer(tt.secret)
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook/ecr", strings.NewReader(ecrBody))
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			rec := httptest.NewRecorder()
+			s.srv.Handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			wantEvents := 0
+			if tt.wantCode == http.StatusAccepted {
+				wantEvents = 1
+			}
+			if len(*events) != wantEvents {
+				t.Errorf("events = %d, want %d", len(*events), wantEvents)
+			}
+		})
+	}
+}
+
+func TestReadGitHubBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		secret    string
+		signature string
+		wantCode  int
+	}{
+		{
+			name:      "X-Hub-Signature-256が正しい",
+			secret:    testSecret,
+			signature: sign(testSecret, ghcrBody),
+			wantCode:  http.StatusAccepted,
+		},
+		{
+			name:      "X-Hub-Signature-256が違う",
+			secret:    testSecret,
+			signature: sign("wrong", ghcrBody),
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:      "sha256=がない",
+			secret:    testSecret,
+			signature: strings.TrimPrefix(sign(testSecret, ghcrBody), "sha256="),
+			wantCode:  http.StatusUnauthorized,
+		},
+		{
+			name:     "X-Hub-Signature-256がない",
+			secret:   testSecret,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "シークレットを設定していない場合は検証しない",
+			secret:   "",
+			wantCode: http.StatusAccepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, events := newTestServer(tt.secret)
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook/github", strings.NewReader(ghcrBody))
+			req.Header.Set(HeaderGitHubEvent, "registry_package")
+			if tt.signature != "" {
+				req.Header.Set(HeaderGitHubSignature, tt.signature)
+			}
+			rec := httptest.NewRecorder()
+			s.srv.Handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if tt.wantCode == http.StatusAccepted {
+				if len(*events) != 1 || (*events)[0].Reference() != "ghcr.io/example/app" {
+					t.Errorf("events = %v", *events)
+				}
+			} else if len(*events) != 0 {
+				t.Errorf("events = %v, want none", *events)
+			}
+		})
+	}
+}
+
+func TestAcceptPartialFailure(t *testing.T) {
+	const harborBody = `{
+  "type": "PUSH_ARTIFACT",
+  "event_data": {
+    "resources": [
+      {"digest": "sha256:0001", "tag": "v1", "resource_url": "harbor.example.com/library/app:v1"},
+      {"digest": "sha256:0001", "tag": "latest", "resource_url": "harbor.example.com/library/app:latest"}
+    ],
+    "repository": {"namespace": "library", "repo_full_name": "library/app"}
+  }
+}`
+
+	tests := []struct {
+		name     string
+		fail     map[string]bool
+		wantCode int
+		wantTags []string
+	}{
+		{
+			name:     "全て処理に回せた",
+			wantCode: http.StatusAccepted,
+			wantTags: []string{"v1", "latest"},
+		},
+		{
+			name:     "一部だけ処理に回せなかった場合は再送させない",
+			fail:     map[string]bool{"latest": true},
+			wantCode: http.StatusAccepted,
+			wantTags: []string{"v1"},
+		},
+		{
+			name:     "1件も処理に回せなかった場合は503",
+			fail:     map[string]bool{"v1": true, "latest": true},
+			wantCode: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tags []string
+			s := NewServer(context.Background(), ":0", "", func(_ context.Context, event *model.ImageEvent) error {
+				if tt.fail[event.Tag] {
+					return errors.New("queue is full")
+				}
+				tags = append(tags, event.Tag)
+				return nil
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook/harbor", strings.NewReader(harborBody))
+			rec := httptest.NewRecorder()
+			s.srv.Handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("code = %d, want %d", rec.Code, tt.wantCode)
+			}
+			if !slices.Equal(tags, tt.wantTags) {
+				t.Errorf("tags = %v, want %v", tags, tt.wantTags)
+			}
+		})
+	}
+}
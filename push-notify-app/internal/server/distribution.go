@@ -0,0 +1,34 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+// distribution はDocker Distribution(registry:2)のnotificationを受け付けます
+// registry:2側ではendpointのheadersにX-Webhook-Secretを設定します
+func (s *Server) distribution(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.readVerifiedBody(w, r)
+	if !ok {
+		return
+	}
+
+	var envelope model.DistributionEnvelope
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		log.Warn(s.ctx, "failed to unmarshal envelope. error: %v", err)
+		http.Error(w, "invalid envelope", http.StatusBadRequest)
+		return
+	}
+
+	events := envelope.ToImageEvents()
+	if len(events) == 0 {
+		log.Debug(s.ctx, "envelope ignored. no tagged push events")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.accept(w, events)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+// ecr はEventBridgeのAPI destinationから送られるECRのpushイベントを受け付けます
+func (s *Server) ecr(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.readVerifiedBody(w, r)
+	if !ok {
+		return
+	}
+
+	var event *model.ECRPushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Warn(s.ctx, "failed to unmarshal event. error: %v", err)
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	if event.Detail.ActionType != model.ECRAcTionPush {
+		log.Debug(s.ctx, "event ignored. action-type: %s", event.Detail.ActionType)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.accept(w, []*model.ImageEvent{event.ToImageEvent()})
+}
+
+// accept はイベントを処理に回し、202を返します
+// 一部のイベントを処理に回せなかった場合、再送されると処理済みのイベントが重複するため202を返してログに残します
+// 1件も処理に回せなかった場合だけ503を返して再送してもらいます
+func (s *Server) accept(w http.ResponseWriter, events []*model.ImageEvent) {
+	var dropped []string
+	for _, event := range events {
+		log.Debug(s.ctx, "event recieved! event: %v", event)
+
+		if err := s.handle(s.ctx, event); err != nil {
+			log.Error(s.ctx, "failed to handle event. error: %v", err)
+			dropped = append(dropped, event.String())
+		}
+	}
+
+	if len(dropped) == len(events) {
+		http.Error(w, "failed to handle event", http.StatusServiceUnavailable)
+		return
+	}
+
+	if len(dropped) != 0 {
+		log.Warn(s.ctx, "some events were dropped. dropped: %v accepted: %d", dropped, len(events)-len(dropped))
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
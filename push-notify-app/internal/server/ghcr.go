@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+const (
+	// HeaderGitHubEvent はGitHubのwebhookのイベント種別を示すヘッダです
+	HeaderGitHubEvent = "X-GitHub-Event"
+	// HeaderGitHubSignature はGitHubのwebhookの署名ヘッダです
+	HeaderGitHubSignature = "X-Hub-Signature-256"
+)
+
+// github はGitHubのwebhookを受け付けます
+// GitHub側ではwebhookのSecretにWEBHOOK_SECRETと同じ値を設定します
+func (s *Server) github(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.readGitHubBody(w, r)
+	if !ok {
+		return
+	}
+
+	switch eventType := r.Header.Get(HeaderGitHubEvent); eventType {
+	case "ping":
+		w.WriteHeader(http.StatusOK)
+	case "registry_package", "package":
+		s.ghcr(w, body)
+	case "pull_request":
+		s.githubPullRequest(w, body)
+	default:
+		log.Debug(s.ctx, "event ignored. event: %s", eventType)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// ghcr はGitHub Container Registryのregistry_package(package)イベントを受け付けます
+func (s *Server) ghcr(w http.ResponseWriter, body []byte) {
+	var event model.GitHubPackageEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Warn(s.ctx, "failed to unmarshal event. error: %v", err)
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	events := event.ToImageEvents()
+	if len(events) == 0 {
+		log.Debug(s.ctx, "event ignored. action: %s", event.Action)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.accept(w, events)
+}
+
+// githubPullRequest は更新PRのマージやラベル付けを受け付け、次の環境への昇格に使います
+func (s *Server) githubPullRequest(w http.ResponseWriter, body []byte) {
+	if s.pullRequest == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var event model.GitHubPullRequestEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Warn(s.ctx, "failed to unmarshal event. error: %v", err)
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.pullRequest(s.ctx, &event); err != nil {
+		log.Error(s.ctx, "failed to handle pull request event. pr: %s error: %v", event.PullRequest.HTMLURL, err)
+		http.Error(w, "failed to handle event", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// readGitHubBody はボディを読み込み、GitHubのwebhook署名を検証します
+// 検証に失敗した場合はレスポンスを書き込み、falseを返します
+func (s *Server) readGitHubBody(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		log.Warn(s.ctx, "failed to read body. error: %v", err)
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return nil, false
+	}
+
+	if s.secret == "" {
+		return body, true
+	}
+
+	signature := r.Header.Get(HeaderGitHubSignature)
+	if !strings.HasPrefix(signature, "sha256=") || !verifySignature(s.secret, strings.TrimPrefix(signature, "sha256="), body) {
+		log.Warn(s.ctx, "github webhook verification failed. path: %s remote: %s", r.URL.Path, r.RemoteAddr)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return body, true
+}
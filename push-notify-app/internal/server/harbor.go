@@ -0,0 +1,34 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+// harbor はHarborのwebhookを受け付けます
+// Harbor側ではAuth HeaderにWEBHOOK_SECRETと同じ値を設定します
+func (s *Server) harbor(w http.ResponseWriter, r *http.Request) {
+	body, ok := s.readVerifiedBody(w, r)
+	if !ok {
+		return
+	}
+
+	var event model.HarborEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		log.Warn(s.ctx, "failed to unmarshal event. error: %v", err)
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+
+	events := event.ToImageEvents()
+	if len(events) == 0 {
+		log.Debug(s.ctx, "event ignored. type: %s", event.Type)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	s.accept(w, events)
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+// maxBodySize はwebhookで受け付けるリクエストボディの上限です
+const maxBodySize = 1 << 20
+
+// HandleFunc は受信したイベントを処理する関数です
+// 更新処理は時間がかかるため、非同期で処理を開始したら即座に返すことを想定しています
+type HandleFunc func(ctx context.Context, event *model.ImageEvent) error
+
+// PullRequestFunc はGitHubのpull_requestイベントを処理する関数です
+type PullRequestFunc func(ctx context.Context, event *model.GitHubPullRequestEvent) error
+
+type Server struct {
+	// ctx はイベント処理に引き渡すcontextです
+	// リクエストのcontextはレスポンス後にキャンセルされるため使いません
+	ctx    context.Context
+	secret string
+	handle HandleFunc
+	// pullRequest はnilの場合はpull_requestイベントを無視します
+	pullRequest PullRequestFunc
+
+	srv *http.Server
+}
+
+func NewServer(ctx context.Context, addr, secret string, handle HandleFunc) *Server {
+	s := &Server{
+		ctx:    ctx,
+		secret: secret,
+		handle: handle,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.healthz)
+	mux.HandleFunc("POST /webhook/ecr", s.ecr)
+	mux.HandleFunc("POST /webhook/distribution", s.distribution)
+	mux.HandleFunc("POST /webhook/harbor", s.harbor)
+	mux.HandleFunc("POST /webhook/ghcr", s.github)
+	mux.HandleFunc("POST /webhook/github", s.github)
+
+	s.srv = &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+// HandlePullRequest はGitHubのpull_requestイベントを受け取る関数を設定します
+func (s *Server) HandlePullRequest(handle PullRequestFunc) {
+	s.pullRequest = handle
+}
+
+func (s *Server) Run() error {
+	log.Info(s.ctx, "webhook server listening. addr: %s", s.srv.Addr)
+	if err := s.srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to run server: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprint(w, "ok")
+}
@@ -0,0 +1,136 @@
+package updater
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/yamledit"
+	"gopkg.in/yaml.v3"
+)
+
+// updateArgoCDApplication はArgo CDのApplicationのイメージを更新し、リポジトリからの相対パスを返します
+// sourceにkustomizeがある場合は.kustomize.imagesを、helmがある場合は.helm.parametersを書き換えます
+func updateArgoCDApplication(root string, c *change) (string, error) {
+	path := filepath.Join(c.path, c.config.targetFile(c.environment))
+
+	err := c.editFile(root, path, func(file *yamledit.File) error {
+		application := findResource(file, "Application", c.config.Target.Name)
+		if application == nil {
+			return fmt.Errorf("application not found. path: %s", path)
+		}
+
+		var sources []*yaml.Node
+		if source, _ := yamledit.Resolve(application, ".spec.source"); source != nil {
+			sources = append(sources, source)
+		}
+		if multiple, _ := yamledit.Resolve(application, ".spec.sources"); multiple != nil {
+			sources = append(sources, multiple.Content...)
+		}
+
+		updated := false
+		for _, source := range sources {
+			if kustomize := yamledit.Lookup(source, "kustomize"); kustomize != nil {
+				if err := setArgoCDKustomizeImage(file, kustomize, c); err != nil {
+					return err
+				}
+				updated = true
+			}
+
+			if helm := yamledit.Lookup(source, "helm"); helm != nil {
+				if err := setArgoCDHelmParameters(file, helm, c); err != nil {
+					return err
+				}
+				updated = true
+			}
+		}
+
+		if !updated {
+			return fmt.Errorf("application has no kustomize or helm source. path: %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// setArgoCDKustomizeImage は.kustomize.imagesのイメージを書き換えます。ない場合は追加します
+// e.g. 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app:v1
+// e.g. example/app=123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app:v1
+func setArgoCDKustomizeImage(file *yamledit.File, kustomize *yaml.Node, c *change) error {
+	imageURI := c.event.Reference()
+
+	images := yamledit.Lookup(kustomize, "images")
+	if images != nil && images.Kind == yaml.SequenceNode {
+		for _, image := range images.Content {
+			name, override, hasOverride := strings.Cut(image.Value, "=")
+			if !hasOverride {
+				override = name
+			}
+
+			ref := parseImageReference(override)
+			if name != imageURI && ref.name != imageURI {
+				continue
+			}
+
+			c.oldTag = ref.tag
+			ref = ref.pin(c.config.pinMode(c.event), c.event)
+			if hasOverride {
+				return file.SetScalar(image, name+"="+ref.String())
+			}
+			return file.SetScalar(image, ref.String())
+		}
+	}
+
+	image := imageReference{name: imageURI}.pin(c.config.pinMode(c.event), c.event).String()
+	if err := file.AppendScalar(kustomize, "images", image); err != nil {
+		return fmt.Errorf("failed to add image. error: %v", err)
+	}
+
+	return nil
+}
+
+// setArgoCDHelmParameters は.helm.parametersの設定したパラメータを書き換えます。ない場合は追加します
+func setArgoCDHelmParameters(file *yamledit.File, helm *yaml.Node, c *change) error {
+	tag := pinnedTag(c.config.pinMode(c.event), c.event)
+	for i, valuePath := range c.config.valuePaths() {
+		name := strings.TrimPrefix(valuePath, ".")
+
+		parameter := findHelmParameter(yamledit.Lookup(helm, "parameters"), name)
+		if parameter == nil {
+			if err := file.AppendMapping(helm, "parameters", []yamledit.Field{
+				{Key: "name", Value: name},
+				{Key: "value", Value: tag},
+			}); err != nil {
+				return fmt.Errorf("failed to add parameter. name: %s error: %v", name, err)
+			}
+			continue
+		}
+
+		if value := yamledit.Lookup(parameter, "value"); value != nil && i == 0 {
+			c.oldTag = value.Value
+		}
+		if err := file.SetField(parameter, "value", tag); err != nil {
+			return fmt.Errorf("failed to set parameter. name: %s error: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// findHelmParameter は.helm.parametersからnameが一致するパラメータを返します
+func findHelmParameter(parameters *yaml.Node, name string) *yaml.Node {
+	if parameters == nil || parameters.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	for _, parameter := range parameters.Content {
+		if n := yamledit.Lookup(parameter, "name"); n != nil && n.Value == name {
+			return parameter
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package updater
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/worker"
+)
+
+// DoneFunc は更新処理が終わったときに呼ばれます
+type DoneFunc func(pullRequestURL string, err error)
+
+// Submitter は更新処理を実行するworkerです
+type Submitter interface {
+	Submit(job worker.Job) error
+}
+
+// Batcher は同じリポジトリに向けたイベントを一定時間まとめ、1つのコミットとPRにします
+type Batcher struct {
+	window    time.Duration
+	submitter Submitter
+
+	mu      sync.Mutex
+	batches map[string]*batch
+}
+
+type batch struct {
+	config  *AppConfig
+	changes []*change
+	// dones はchangesと同じ順番で、変更ごとに結果を渡すdoneです
+	dones [][]DoneFunc
+}
+
+func NewBatcher(window time.Duration, submitter Submitter) *Batcher {
+	return &Batcher{
+		window:    window,
+		submitter: submitter,
+		batches:   make(map[string]*batch),
+	}
+}
+
+// Add はイベントをリポジトリごとのバッチに追加します
+// 設定にマッチしないなど更新できないイベントはその場でdoneを呼びます
+func (b *Batcher) Add(ctx context.Context, config *AppConfig, event *model.ImageEvent, done DoneFunc) {
+	c, err := resolve(ctx, config, event)
+	if err != nil {
+		done("", err)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, ok := b.batches[c.cloneURL]
+	if !ok {
+		current = &batch{config: config}
+		b.batches[c.cloneURL] = current
+
+		key := c.cloneURL
+		time.AfterFunc(b.window, func() {
+			b.flush(ctx, key)
+		})
+	}
+
+	// 同じイメージと環境の変更は新しい方で置き換える
+	for i, existing := range current.changes {
+		if existing.event.Reference() == c.event.Reference() && existing.repositoryDir == c.repositoryDir {
+			log.Debug(ctx, "batched change replaced. image: %s tag: %s -> %s", c.event.Reference(), existing.event.Tag, c.event.Tag)
+			current.changes[i] = c
+			current.dones[i] = append(current.dones[i], done)
+			return
+		}
+	}
+
+	log.Debug(ctx, "change batched. repository: %s image: %s:%s", c.cloneURL, c.event.Reference(), c.event.Tag)
+	current.changes = append(current.changes, c)
+	current.dones = append(current.dones, []DoneFunc{done})
+}
+
+// flush はバッチをworkerに渡して更新します
+func (b *Batcher) flush(ctx context.Context, key string) {
+	b.mu.Lock()
+	current := b.batches[key]
+	delete(b.batches, key)
+	b.mu.Unlock()
+
+	if current == nil {
+		return
+	}
+
+	log.Info(ctx, "flushing batch. repository: %s changes: %d", key, len(current.changes))
+
+	if err := b.submitter.Submit(func(ctx context.Context) {
+		pullRequestURL, err := update(ctx, current.config, current.changes)
+		for i, c := range current.changes {
+			// 更新できなかった変更には、その変更のエラーだけを渡す
+			if c.err != nil {
+				current.done(i, "", c.err)
+				continue
+			}
+			// 既に更新済みの変更はPRに含めていない
+			if !c.changed() {
+				current.done(i, "", nil)
+				continue
+			}
+			current.done(i, pullRequestURL, err)
+		}
+	}); err != nil {
+		log.Error(ctx, "failed to submit batch. repository: %s error: %v", key, err)
+		for i := range current.changes {
+			current.done(i, "", err)
+		}
+	}
+}
+
+// done はi番目の変更のdoneを全て呼びます
+func (b *batch) done(i int, pullRequestURL string, err error) {
+	for _, done := range b.dones[i] {
+		done(pullRequestURL, err)
+	}
+}
+
+// FlushAll は待機中のバッチを待たずに全てworkerに渡します。shutdown時に使います
+func (b *Batcher) FlushAll(ctx context.Context) {
+	b.mu.Lock()
+	keys := make([]string, 0, len(b.batches))
+	for key := range b.batches {
+		keys = append(keys, key)
+	}
+	b.mu.Unlock()
+
+	for _, key := range keys {
+		b.flush(ctx, key)
+	}
+}
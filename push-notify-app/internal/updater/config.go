@@ -1,11 +1,14 @@
 package updater
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strings"
 
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/git"
 	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
 	"gopkg.in/yaml.v2"
 )
@@ -25,6 +28,17 @@ type AppConfig struct {
 
 	// required - input json only
 	RegistryConfig []RegistryConfig
+
+	// optional
+	// nilの場合はイベントごとにcloneします
+	CloneCache *git.CloneCache
+
+	// optional
+	// trueの場合は全ての設定でコミットとpushをせず、差分と作成するPRを出力します
+	DryRun bool
+	// optional
+	// dry runの結果の出力先 nilの場合は標準出力
+	DryRunOutput io.Writer
 }
 
 type RegistryConfig struct {
@@ -33,8 +47,15 @@ type RegistryConfig struct {
 	// e.g. latest
 	AllowImageTag string `yaml:"allowImageTag"`
 	DenyImageTag  string `yaml:"denyImageTag"`
+	// optional
+	// semverのタグだけを更新する場合に指定します
+	// 指定した場合はマニフェストのバージョンより低いタグには更新しません
+	SemVer *SemVerPolicy `yaml:"semver"`
 	// e.g. 211125717884.dkr.ecr.ap-northeast-1.amazonaws.com/example/sample/sample-app/app
 	// e.g. 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/*/$1/$2/$3
+	// e.g. registry.example.com:5000/$1/$2 (registry:2の場合はEnvのキーにhostを指定します)
+	// e.g. harbor.example.com/*/$1/$2
+	// e.g. ghcr.io/murasame29/$1
 	RegitryURI string `yaml:"registryURI"`
 	// e.g.github.com/murasame29/image-registry-push-notify/services/sample/sample-app/app/dev/overlays
 	// e.g.github.com/murasame29/image-registry-push-notify/services/$1/$2/$3/$env/overlays
@@ -44,27 +65,78 @@ type RegistryConfig struct {
 	// e.g. 123456789012: dev
 	// e.g. 234567890123: staging
 	// e.g. 345678901234: prod
+	// e.g. library: dev (Harborの場合はprojectかhostをキーにします)
+	// e.g. murasame29: dev (GHCRの場合はownerかhostをキーにします)
 	Env map[string]string `yaml:"env"`
+	// optional
+	// 同じリポジトリへの更新を直列化する単位
+	// repository (default): リポジトリ単位
+	// path: 更新するディレクトリ単位 (monorepoで別サービスを並行して更新したい場合)
+	LockScope LockScope `yaml:"lockScope"`
+	// optional
+	// イベントを送れないregistryをpollする場合に指定します
+	Poll *PollConfig `yaml:"poll"`
+	// optional
+	// 大きなmonorepoでcloneする量を減らす場合に指定します
+	Clone *CloneConfig `yaml:"clone"`
+	// optional
+	// 更新するファイルの種類 指定がない場合はkustomization.yamlを更新します
+	Target *TargetConfig `yaml:"target"`
+	// optional
+	// イメージの指定方法
+	// tag (default): タグを書きます
+	// digest: digestを書きます (kustomizeはdigest:、それ以外はtag@sha256:...)
+	// both: digestを書き、タグをコメントに残します (kustomize以外はtag@sha256:...)
+	Pin PinMode `yaml:"pin"`
+	// optional
+	// 更新PRがマージされたときに次の環境へ昇格する設定 (webhookモードでGitHubのpull_requestイベントを受け取る必要があります)
+	// e.g. - from: dev
+	//        to: staging
+	//      - from: staging
+	//        to: prod
+	//        gate: soak
+	//        soak: 1h
+	Promotion []PromotionStage `yaml:"promotion"`
+	// optional
+	// trueの場合はこの設定に一致したイベントではコミットとpushをせず、差分と作成するPRを出力します
+	// 新しいサービスを追加するときに確認するために使います
+	DryRun bool `yaml:"dryRun"`
+}
+
+type LockScope string
+
+const (
+	LockScopeRepository LockScope = "repository"
+	LockScopePath       LockScope = "path"
+)
+
+type PollConfig struct {
+	// e.g. registry.example.com/team/app
+	Repositories []string `yaml:"repositories"`
+	// digestが変わったかを毎回確認するタグ
+	// e.g. stable
+	MutableTags []string `yaml:"mutableTags"`
+}
+
+type CloneConfig struct {
+	// 取得するコミット履歴の深さ 0の場合は全履歴
+	// e.g. 1
+	Depth int `yaml:"depth"`
+	// trueの場合はGitHubRepositoryから決まるディレクトリだけをcheckoutします
+	// clone cacheを使う場合は無視されます
+	Sparse bool `yaml:"sparse"`
 }
 
-func (c *RegistryConfig) buildRepositoryName(event *model.ECRPushEvent) (string, error) {
-	environment, ok := c.Env[event.Account]
+func (c *RegistryConfig) buildRepositoryName(event *model.ImageEvent) (string, error) {
+	environment, ok := c.environment(event)
 	if !ok {
 		return "", fmt.Errorf("environment not match")
 	}
 
-	variableMap := make(map[string]string)
-
 	// $1 ,$2 ..に対応対応するピースを探す
-	registryName := removeEmpty(strings.Split(c.RegitryURI, "/"))
-	splitedRegistryName := removeEmpty(strings.Split(event.Detail.RepositoryName, "/"))
-	for i := range registryName {
-		if strings.Contains(registryName[i], "*") {
-			continue
-		}
-		if strings.Contains(registryName[i], "$") {
-			variableMap[registryName[i]] = splitedRegistryName[i]
-		}
+	variableMap, ok := matchReference(c.RegitryURI, event)
+	if !ok {
+		return "", fmt.Errorf("registry uri not match. %s", event.Reference())
 	}
 
 	repositoryName := c.GitHubRepository
@@ -89,6 +161,58 @@ func (c *RegistryConfig) buildRepositoryName(event *model.ECRPushEvent) (string,
 	return repositoryName, nil
 }
 
+// githubHost はgithubRepositoryの先頭に書くhostです
+const githubHost = "github.com"
+
+// splitGitHubRepository はgithubRepositoryをowner, リポジトリ名, リポジトリ内のパスに分けます
+// githubRepositoryはスキームを付けずに github.com/owner/repo[/path] と書きます
+// e.g. github.com/murasame29/image-registry-push-notify/services/sample/sample-app/app/overlays/dev
+func splitGitHubRepository(githubRepository string) (string, string, string, error) {
+	parts := strings.SplitN(githubRepository, "/", 4)
+	if len(parts) < 3 || parts[0] != githubHost || parts[1] == "" || parts[2] == "" {
+		return "", "", "", fmt.Errorf("githubRepository must be github.com/owner/repo[/path]. githubRepository: %s", githubRepository)
+	}
+
+	path := ""
+	if len(parts) == 4 {
+		path = strings.Trim(parts[3], "/")
+	}
+
+	return parts[1], parts[2], path, nil
+}
+
+// environment はイベントに対応する環境名を返します
+// ECRはaccount、Harborはproject、GHCRはownerで引き、見つからない場合はregistryのhostで引きます
+// 昇格のイベントのように環境が指定されている場合は、その環境がEnvかPromotionにあるかを確認します
+func (c *RegistryConfig) environment(event *model.ImageEvent) (string, bool) {
+	if event.Environment != "" {
+		return event.Environment, c.hasEnvironment(event.Environment)
+	}
+
+	if environment, ok := c.Env[event.Source.Account]; ok {
+		return environment, true
+	}
+
+	environment, ok := c.Env[event.Registry]
+	return environment, ok
+}
+
+func (c *RegistryConfig) hasEnvironment(environment string) bool {
+	for _, env := range c.Env {
+		if env == environment {
+			return true
+		}
+	}
+
+	for _, stage := range c.Promotion {
+		if stage.From == environment || stage.To == environment {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *RegistryConfig) checkAllowTag(tag string) bool {
 	tags := strings.Split(c.AllowImageTag, ":")
 	if len(tags) == 2 {
@@ -125,22 +249,49 @@ func (c *RegistryConfig) checkDenyTag(tag string) bool {
 	return false
 }
 
+// NewConfigWithFile は設定ファイルを読み込み、検証します
+// 知らないキー(e.g. typo)があった場合もエラーにし、見つかった問題はまとめて返します
 func NewConfigWithFile(path string) ([]RegistryConfig, error) {
-	var config []RegistryConfig
-
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	config, err := parseConfigData(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config. path: %s\n%w", path, err)
+	}
+
+	return config, nil
+}
+
+func parseConfigData(data []byte) ([]RegistryConfig, error) {
+	var config []RegistryConfig
+
+	var errs []error
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		// 知らないキーや型の違いの場合は読めた部分の検証も続ける
+		var typeError *yaml.TypeError
+		if !errors.As(err, &typeError) {
+			return nil, err
+		}
+		for _, message := range typeError.Errors {
+			errs = append(errs, errors.New(message))
+		}
+	}
+
+	if err := ValidateConfig(config); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := errors.Join(errs...); err != nil {
 		return nil, err
 	}
 
 	return config, nil
 }
 
-func (c *AppConfig) parseConfig(event *model.ECRPushEvent) (*RegistryConfig, error) {
+func (c *AppConfig) parseConfig(event *model.ImageEvent) (*RegistryConfig, error) {
 	registryConfig, ok := filterRegistryConfigs(event, c.RegistryConfig)
 	if !ok {
 		return nil, fmt.Errorf("config dont match")
@@ -149,38 +300,68 @@ func (c *AppConfig) parseConfig(event *model.ECRPushEvent) (*RegistryConfig, err
 	return registryConfig, nil
 }
 
-func filterRegistryConfigs(event *model.ECRPushEvent, registryConfig []RegistryConfig) (*RegistryConfig, bool) {
-	eventRepositoryPath := strings.Split(event.Detail.RepositoryName, "/")
+func filterRegistryConfigs(event *model.ImageEvent, registryConfig []RegistryConfig) (*RegistryConfig, bool) {
+	i, ok := findRegistryConfig(event, registryConfig)
+	if !ok {
+		return nil, false
+	}
+
+	config := registryConfig[i]
+	return &config, true
+}
 
-	for _, config := range registryConfig {
-		if config.Region != event.Region {
+// findRegistryConfig はイベントに一致する最初の設定の位置を返します
+func findRegistryConfig(event *model.ImageEvent, registryConfig []RegistryConfig) (int, bool) {
+	for i, config := range registryConfig {
+		if config.Region != event.Source.Region {
 			continue
 		}
-		if _, ok := config.Env[event.Account]; !ok {
+		if _, ok := config.environment(event); !ok {
 			continue
 		}
-		repositoryPath := strings.Split(config.RegitryURI, "/")
-		if filterRegistryConfig(repositoryPath, eventRepositoryPath) {
-			return &config, true
+		if _, ok := matchReference(config.RegitryURI, event); ok {
+			return i, true
 		}
 	}
-	return nil, false
+	return 0, false
 }
 
-func filterRegistryConfig(repositoryPath, eventRepositoryPath []string) bool {
-	repositoryPath = removeEmpty(repositoryPath)
-	eventRepositoryPath = removeEmpty(eventRepositoryPath)
-	for i := range len(repositoryPath) {
-		if repositoryPath[i] == "*" || strings.Contains(repositoryPath[i], "$") {
+// matchReference はregistryURIのパターンとイメージの参照を比較し、$1, $2 ..に対応する値を返します
+// パターンの先頭がhostの場合はhostを含めた参照全体を、そうでない場合(e.g. /*/$1/$2)はrepositoryのパスだけを比較します
+func matchReference(registryURI string, event *model.ImageEvent) (map[string]string, bool) {
+	pattern := removeEmpty(strings.Split(registryURI, "/"))
+	reference := removeEmpty(strings.Split(event.Repository, "/"))
+	if len(pattern) != 0 && isRegistryHost(pattern[0]) {
+		reference = append([]string{event.Registry}, reference...)
+	}
+
+	if len(reference) < len(pattern) {
+		return nil, false
+	}
+
+	variableMap := make(map[string]string)
+	for i := range pattern {
+		if pattern[i] == "*" {
 			continue
 		}
 
-		if repositoryPath[i] != eventRepositoryPath[i] {
-			return false
+		if strings.HasPrefix(pattern[i], "$") {
+			variableMap[pattern[i]] = reference[i]
+			continue
+		}
+
+		if pattern[i] != reference[i] {
+			return nil, false
 		}
 	}
 
-	return true
+	return variableMap, true
+}
+
+// isRegistryHost はregistryURIの要素がhostかどうかを判定します
+// e.g. registry.example.com:5000, localhost
+func isRegistryHost(segment string) bool {
+	return strings.ContainsAny(segment, ".:") || segment == "localhost"
 }
 
 func removeEmpty(in []string) []string {
@@ -0,0 +1,76 @@
+package updater
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/diff"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/git"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+)
+
+// dryRun は変更をコミットせずに出力だけするかを返します
+// まとめて更新する場合は1つでもdry runの設定があれば全体をdry runにします
+func dryRun(config *AppConfig, changes []*change) bool {
+	if config.DryRun {
+		return true
+	}
+
+	for _, c := range changes {
+		if c.config.DryRun {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeDryRun はコミットとpushの代わりに、変更の差分と作成するブランチ、コミット、PRを出力します
+// worktreeの変更はclone cacheを次に使うときにresetされます
+func writeDryRun(ctx context.Context, config *AppConfig, github *git.GitHub, repo *gogit.Repository, root, branch string, changes []*change, paths []string) error {
+	var out strings.Builder
+	fmt.Fprintf(&out, "=== dry run: %s ===\n", changes[0].cloneURL)
+	fmt.Fprintf(&out, "branch: %s\n", branch)
+	fmt.Fprintf(&out, "commit: %s\n", strings.TrimSpace(commitMessage(changes)))
+	fmt.Fprintf(&out, "pull request: %s\n\n", pullRequestTitle(changes))
+	out.WriteString(buildPullRequestBody(changes))
+	out.WriteString("\n")
+
+	changed := false
+	for _, path := range paths {
+		before, err := github.HeadFile(repo, path)
+		if err != nil {
+			return err
+		}
+
+		after, err := os.ReadFile(filepath.Join(root, path))
+		if err != nil {
+			return fmt.Errorf("failed to read file. path: %s error: %v", path, err)
+		}
+
+		if d := diff.Unified(path, before, after); d != "" {
+			out.WriteString(d)
+			changed = true
+		}
+	}
+	if !changed {
+		out.WriteString("no changes\n")
+	}
+
+	log.Info(ctx, "dry run. skip commit and push. branch: %s", branch)
+
+	output := config.DryRunOutput
+	if output == nil {
+		output = os.Stdout
+	}
+	if _, err := io.WriteString(output, out.String()); err != nil {
+		return fmt.Errorf("failed to write dry run. error: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package updater
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/yamledit"
+)
+
+// updateFluxHelmRelease はFluxのHelmReleaseのspec.valuesのタグを更新し、リポジトリからの相対パスを返します
+func updateFluxHelmRelease(root string, c *change) (string, error) {
+	path := filepath.Join(c.path, c.config.targetFile(c.environment))
+
+	err := c.editFile(root, path, func(file *yamledit.File) error {
+		release := findResource(file, "HelmRelease", c.config.Target.Name)
+		if release == nil {
+			return fmt.Errorf("helm release not found. path: %s", path)
+		}
+
+		values, _ := yamledit.Resolve(release, ".spec.values")
+		if values == nil {
+			return fmt.Errorf("helm release has no spec.values. path: %s", path)
+		}
+
+		return setValues(file, values, c)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
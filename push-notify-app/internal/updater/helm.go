@@ -0,0 +1,43 @@
+package updater
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/yamledit"
+	"gopkg.in/yaml.v3"
+)
+
+// updateHelmValues はvaluesファイルの設定したパスのタグを更新し、リポジトリからの相対パスを返します
+func updateHelmValues(root string, c *change) (string, error) {
+	path := filepath.Join(c.path, c.config.targetFile(c.environment))
+
+	err := c.editFile(root, path, func(values *yamledit.File) error {
+		if len(values.Documents) == 0 {
+			return fmt.Errorf("values is empty. path: %s", path)
+		}
+		return setValues(values, values.Documents[0], c)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// setValues はvaluesのノードの設定したパスのタグを全て書き換えます
+func setValues(file *yamledit.File, values *yaml.Node, c *change) error {
+	tag := pinnedTag(c.config.pinMode(c.event), c.event)
+	for i, valuePath := range c.config.valuePaths() {
+		oldTag, err := file.SetPath(values, valuePath, tag)
+		if err != nil {
+			return fmt.Errorf("failed to set tag. path: %s error: %v", valuePath, err)
+		}
+
+		if i == 0 {
+			c.oldTag = oldTag
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package updater
+
+import (
+	"strings"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+type PinMode string
+
+const (
+	// PinTag はタグだけを書きます
+	PinTag PinMode = "tag"
+	// PinDigest はdigestだけを書きます
+	PinDigest PinMode = "digest"
+	// PinBoth はdigestを書き、タグも分かるように残します
+	PinBoth PinMode = "both"
+)
+
+// pinMode はイベントに対して使うピン留めの方法を返します
+// イベントにdigestがない場合はタグで更新します
+func (c *RegistryConfig) pinMode(event *model.ImageEvent) PinMode {
+	if c.Pin == "" || event.Digest == "" {
+		return PinTag
+	}
+
+	return c.Pin
+}
+
+// pinnedTag はタグを書く場所(e.g. helmの.image.tag)に書く値を返します
+// digestを使う場合は tag@sha256:... にします
+func pinnedTag(mode PinMode, event *model.ImageEvent) string {
+	if mode == PinTag {
+		return event.Tag
+	}
+
+	return event.Tag + "@" + event.Digest
+}
+
+// pin はイメージの参照のタグとdigestをモードに合わせて書き換えます
+// タグで更新する場合、元の参照がdigestを持っていればdigestも書き換えます
+func (r imageReference) pin(mode PinMode, event *model.ImageEvent) imageReference {
+	switch mode {
+	case PinDigest:
+		r.tag = ""
+		r.digest = event.Digest
+	case PinBoth:
+		r.tag = event.Tag
+		r.digest = event.Digest
+	default:
+		r.tag = event.Tag
+		if r.digest != "" && event.Digest != "" {
+			r.digest = event.Digest
+		}
+	}
+
+	return r
+}
+
+// imageReference はコンテナイメージの参照を分解したものです
+// e.g. 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app:v1@sha256:...
+type imageReference struct {
+	// e.g. 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+	name   string
+	tag    string
+	digest string
+}
+
+func parseImageReference(image string) imageReference {
+	var ref imageReference
+
+	image, ref.digest, _ = strings.Cut(image, "@")
+
+	// registryのportと区別するため、最後の/より後ろの:だけをタグとして扱う
+	slash := strings.LastIndex(image, "/")
+	if colon := strings.LastIndex(image, ":"); colon > slash {
+		ref.tag = image[colon+1:]
+		image = image[:colon]
+	}
+	ref.name = image
+
+	return ref
+}
+
+func (r imageReference) String() string {
+	image := r.name
+	if r.tag != "" {
+		image += ":" + r.tag
+	}
+	if r.digest != "" {
+		image += "@" + r.digest
+	}
+
+	return image
+}
@@ -0,0 +1,125 @@
+package updater
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/yamledit"
+	"gopkg.in/yaml.v3"
+)
+
+// updateKustomization はkustomization.yamlのイメージを更新し、リポジトリからの相対パスを返します
+// 該当するエントリのnewTagかdigestだけを書き換え、それ以外の行やコメントはそのまま残します
+func updateKustomization(root string, c *change) (string, error) {
+	path := filepath.Join(c.path, c.config.targetFile(c.environment))
+
+	err := c.editFile(root, path, func(kustomization *yamledit.File) error {
+		if len(kustomization.Documents) == 0 {
+			return fmt.Errorf("kustomization.yaml is empty. path: %s", path)
+		}
+		document := kustomization.Documents[0]
+
+		imageURI := c.event.Reference()
+		mode := c.config.pinMode(c.event)
+		fields := kustomizeImageFields(mode, c.event)
+
+		images := findImages(yamledit.Lookup(document, "images"), imageURI)
+		if len(images) == 0 {
+			// .imagesがないから作る
+			if err := kustomization.AppendMapping(document, "images", append([]yamledit.Field{
+				{Key: "name", Value: imageURI},
+			}, fields...)); err != nil {
+				return fmt.Errorf("failed to add image. error: %v", err)
+			}
+			return nil
+		}
+
+		// 同じイメージのエントリが複数ある場合は全て更新する
+		for _, image := range images {
+			if c.oldTag == "" {
+				c.oldTag = kustomizeImageTag(image)
+			}
+
+			if err := setKustomizeImage(kustomization, image, mode, fields, c.event); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// kustomizeImageTag はエントリの今のタグを返します
+// digestで固定している場合はnewTagがないため、digestのコメントに残したタグを使います
+// e.g. digest: sha256:... # v1.2.0
+func kustomizeImageTag(image *yaml.Node) string {
+	if newTag := yamledit.Lookup(image, "newTag"); newTag != nil {
+		return newTag.Value
+	}
+
+	if digest := yamledit.Lookup(image, "digest"); digest != nil {
+		return strings.TrimSpace(strings.TrimPrefix(digest.LineComment, "#"))
+	}
+
+	return ""
+}
+
+// kustomizeImageFields は.imagesのエントリに書くフィールドを返します
+func kustomizeImageFields(mode PinMode, event *model.ImageEvent) []yamledit.Field {
+	switch mode {
+	case PinDigest:
+		return []yamledit.Field{{Key: "digest", Value: event.Digest}}
+	case PinBoth:
+		return []yamledit.Field{{Key: "digest", Value: event.Digest, Comment: event.Tag}}
+	}
+
+	return []yamledit.Field{{Key: "newTag", Value: event.Tag}}
+}
+
+// setKustomizeImage は.imagesのエントリ1つを更新します
+func setKustomizeImage(kustomization *yamledit.File, image *yaml.Node, mode PinMode, fields []yamledit.Field, event *model.ImageEvent) error {
+	for _, field := range fields {
+		if err := kustomization.Set(image, field); err != nil {
+			return fmt.Errorf("failed to set %s. error: %v", field.Key, err)
+		}
+	}
+
+	// タグで更新する場合でもdigestがあるとそちらが使われるので、digestも書き換える
+	if mode == PinTag {
+		if digest := yamledit.Lookup(image, "digest"); digest != nil && event.Digest != "" {
+			return kustomization.SetScalar(digest, event.Digest)
+		}
+		return nil
+	}
+
+	// digestを書く場合はnewTagは使われないので消す
+	if err := kustomization.DeleteField(image, "newTag"); err != nil {
+		return fmt.Errorf("failed to delete newTag. error: %v", err)
+	}
+	return nil
+}
+
+// findImages は.imagesからイメージに対応するエントリを全て返します
+// nameが一致するものに加えて、newNameでイメージを差し替えているエントリも対象にします
+// e.g. name: app, newName: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+func findImages(images *yaml.Node, imageURI string) []*yaml.Node {
+	if images == nil || images.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	var matched []*yaml.Node
+	for _, image := range images.Content {
+		name := yamledit.Lookup(image, "name")
+		newName := yamledit.Lookup(image, "newName")
+		if (name != nil && name.Value == imageURI) || (newName != nil && newName.Value == imageURI) {
+			matched = append(matched, image)
+		}
+	}
+	return matched
+}
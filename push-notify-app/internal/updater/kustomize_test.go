@@ -0,0 +1,169 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+func TestUpdateKustomization(t *testing.T) {
+	tests := []struct {
+		name       string
+		pin        PinMode
+		input      string
+		want       string
+		wantOldTag string
+	}{
+		{
+			name: "既存のエントリのnewTagを書き換える",
+			input: `namespace: samples
+images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app # app
+  newTag: v1 # current
+resources:
+- ../../base/
+`,
+			want: `namespace: samples
+images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app # app
+  newTag: v2 # current
+resources:
+- ../../base/
+`,
+			wantOldTag: "v1",
+		},
+		{
+			name: "newNameで参照しているエントリを書き換える",
+			input: `images:
+- name: app
+  newName: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: v1
+- name: other
+  newTag: v1
+`,
+			want: `images:
+- name: app
+  newName: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: v2
+- name: other
+  newTag: v1
+`,
+			wantOldTag: "v1",
+		},
+		{
+			name: "同じイメージのエントリを全て書き換える",
+			input: `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: v1
+- name: worker
+  newName: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: v0
+`,
+			want: `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: v2
+- name: worker
+  newName: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: v2
+`,
+			wantOldTag: "v1",
+		},
+		{
+			name: "エントリがない場合は追加する",
+			input: `resources:
+- ../../base/
+`,
+			want: `resources:
+- ../../base/
+images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: v2
+`,
+		},
+		{
+			name: "nameが一致しないエントリは書き換えない",
+			input: `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app-worker
+  newTag: v1
+`,
+			want: `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app-worker
+  newTag: v1
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: v2
+`,
+		},
+		{
+			name: "digestで固定する場合はnewTagを消す",
+			pin:  PinBoth,
+			input: `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: v1
+`,
+			want: `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  digest: sha256:0123 # v2
+`,
+			wantOldTag: "v1",
+		},
+		{
+			name: "既存のdigestとコメントを書き換える",
+			pin:  PinBoth,
+			input: `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  digest: sha256:0000 # v1
+`,
+			want: `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  digest: sha256:0123 # v2
+`,
+			wantOldTag: "v1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "overlays", "dev")
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte(tt.input), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			c := &change{
+				event: &model.ImageEvent{
+					Registry:   "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com",
+					Repository: "example/app",
+					Tag:        "v2",
+					Digest:     "sha256:0123",
+				},
+				config:      &RegistryConfig{Pin: tt.pin},
+				environment: "dev",
+				path:        "overlays/dev",
+			}
+
+			path, err := updateKustomization(root, c)
+			if err != nil {
+				t.Fatalf("updateKustomization() error = %v", err)
+			}
+			if path != "overlays/dev/kustomization.yaml" {
+				t.Errorf("path = %s", path)
+			}
+
+			got, err := os.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("kustomization.yaml\ngot:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if c.oldTag != tt.wantOldTag {
+				t.Errorf("oldTag = %s, want %s", c.oldTag, tt.wantOldTag)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package updater
+
+import (
+	"context"
+	"sync"
+)
+
+// repositoryLocks は同じGitOpsリポジトリへの更新を直列化するためのロックです
+var repositoryLocks = newKeyedMutex()
+
+// keyedMutex はキーごとに排他制御するmutexです
+// 使われていないキーのロックは解放時に削除されます
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sem  chan struct{}
+	refs int
+}
+
+func newKeyedMutex() *keyedMutex {
+	return &keyedMutex{
+		locks: make(map[string]*keyedLock),
+	}
+}
+
+// Lock はkeyのロックを取得し、解放する関数を返します
+// ロックを待っている間にctxが終了した場合はエラーを返します
+func (k *keyedMutex) Lock(ctx context.Context, key string) (func(), error) {
+	k.mu.Lock()
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{sem: make(chan struct{}, 1)}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mu.Unlock()
+
+	select {
+	case lock.sem <- struct{}{}:
+		return func() {
+			<-lock.sem
+			k.release(key, lock)
+		}, nil
+	case <-ctx.Done():
+		k.release(key, lock)
+		return nil, ctx.Err()
+	}
+}
+
+func (k *keyedMutex) release(key string, lock *keyedLock) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	lock.refs--
+	if lock.refs == 0 {
+		delete(k.locks, key)
+	}
+}
@@ -0,0 +1,114 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/yamledit"
+	"gopkg.in/yaml.v3"
+)
+
+// containerKeys はコンテナの一覧を持つキーです
+// Pod, Deployment, StatefulSet, CronJob などリソースの種類に関係なく、このキーの下にあるコンテナを更新します
+var containerKeys = []string{"containers", "initContainers"}
+
+// updateManifests はディレクトリ直下にあるマニフェストのうち、イメージが一致するコンテナを全て更新します
+// YAMLとして読めないファイル(e.g. Helmのテンプレート)は飛ばします
+// 更新したファイルのリポジトリからの相対パスを返します
+func updateManifests(ctx context.Context, root string, c *change) ([]string, error) {
+	dir := filepath.Join(root, c.path)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest dir. path: %s error: %v", c.path, err)
+	}
+
+	var paths []string
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || (ext != ".yaml" && ext != ".yml") {
+			continue
+		}
+
+		path := filepath.Join(c.path, file.Name())
+		found := false
+		if err := c.editFile(root, path, func(manifest *yamledit.File) error {
+			var err error
+			found, err = updateContainerImages(manifest, c)
+			return err
+		}); err != nil {
+			if errors.Is(err, errParseFile) {
+				log.Warn(ctx, "skip manifest. error: %v", err)
+				continue
+			}
+			return nil, fmt.Errorf("failed to update manifest. error: %v", err)
+		}
+
+		if found {
+			paths = append(paths, path)
+		}
+	}
+
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("image not found in manifests. path: %s image: %s", c.path, c.event.Reference())
+	}
+
+	return paths, nil
+}
+
+// updateContainerImages はイメージが一致するコンテナを全て更新し、一致するコンテナがあったかを返します
+func updateContainerImages(manifest *yamledit.File, c *change) (bool, error) {
+	var images []*yaml.Node
+	for _, document := range manifest.Documents {
+		// apiVersionとkindのないドキュメントはKubernetesのリソースではないので見ない
+		if yamledit.Lookup(document, "apiVersion") == nil || yamledit.Lookup(document, "kind") == nil {
+			continue
+		}
+		images = append(images, findContainerImages(document, c.event.Reference())...)
+	}
+
+	for _, image := range images {
+		ref := parseImageReference(image.Value)
+		if c.oldTag == "" {
+			c.oldTag = ref.tag
+		}
+
+		ref = ref.pin(c.config.pinMode(c.event), c.event)
+		if err := manifest.SetScalar(image, ref.String()); err != nil {
+			return false, err
+		}
+	}
+
+	return len(images) != 0, nil
+}
+
+// findContainerImages はノードの下にあるcontainers/initContainersのうち、イメージ名が一致するものの.imageを返します
+func findContainerImages(node *yaml.Node, name string) []*yaml.Node {
+	var images []*yaml.Node
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if slices.Contains(containerKeys, key.Value) && value.Kind == yaml.SequenceNode {
+				for _, container := range value.Content {
+					image := yamledit.Lookup(container, "image")
+					if image != nil && image.Kind == yaml.ScalarNode && parseImageReference(image.Value).name == name {
+						images = append(images, image)
+					}
+				}
+				continue
+			}
+			images = append(images, findContainerImages(value, name)...)
+		}
+	case yaml.SequenceNode:
+		for _, child := range node.Content {
+			images = append(images, findContainerImages(child, name)...)
+		}
+	}
+
+	return images
+}
@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+func TestUpdateManifests(t *testing.T) {
+	const image = "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app"
+
+	tests := []struct {
+		name      string
+		files     map[string]string
+		want      map[string]string
+		wantPaths []string
+		wantErr   bool
+	}{
+		{
+			name: "読めないファイルとKubernetesのリソースでないドキュメントは飛ばす",
+			files: map[string]string{
+				"deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: ` + image + `:v1
+`,
+				"template.yaml": `{{- if .Values.enabled }}
+image: {{ .Values.image }}
+{{- end }}
+`,
+				"values.yaml": `containers:
+- image: ` + image + `:v1
+`,
+			},
+			want: map[string]string{
+				"deployment.yaml": `apiVersion: apps/v1
+kind: Deployment
+spec:
+  template:
+    spec:
+      containers:
+      - name: app
+        image: ` + image + `:v2
+`,
+				"values.yaml": `containers:
+- image: ` + image + `:v1
+`,
+			},
+			wantPaths: []string{"overlays/dev/deployment.yaml"},
+		},
+		{
+			name: "一致するコンテナがない場合はエラー",
+			files: map[string]string{
+				"template.yaml": "{{ .Values.image }}: [\n",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			dir := filepath.Join(root, "overlays", "dev")
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			c := &change{
+				event: &model.ImageEvent{
+					Registry:   "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com",
+					Repository: "example/app",
+					Tag:        "v2",
+				},
+				config:      &RegistryConfig{Target: &TargetConfig{Type: TargetManifest}},
+				environment: "dev",
+				path:        "overlays/dev",
+			}
+
+			paths, err := updateManifests(context.Background(), root, c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("updateManifests() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(paths) != len(tt.wantPaths) || paths[0] != tt.wantPaths[0] {
+				t.Errorf("paths = %v, want %v", paths, tt.wantPaths)
+			}
+			for name, want := range tt.want {
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("%s\ngot:\n%s\nwant:\n%s", name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package updater
+
+import (
+	"fmt"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+// Match はイベントに一致した設定と更新するディレクトリです
+type Match struct {
+	// Index は設定ファイルの中で一致したルールの位置です
+	Index  int
+	Config *RegistryConfig
+	// e.g. dev
+	Environment string
+	// e.g. github.com/murasame29/image-registry-push-notify/services/sample/sample-app/app/overlays/dev
+	RepositoryDir string
+}
+
+// MatchEvent はイベントに一致する設定を探し、更新するディレクトリを組み立てます
+// タグのallow, denyは確認しません。リポジトリへのアクセスもしません
+func MatchEvent(config *AppConfig, event *model.ImageEvent) (*Match, error) {
+	i, ok := findRegistryConfig(event, config.RegistryConfig)
+	if !ok {
+		return nil, fmt.Errorf("config dont match")
+	}
+
+	registryConfig := config.RegistryConfig[i]
+	environment, _ := registryConfig.environment(event)
+
+	repositoryDir, err := registryConfig.buildRepositoryName(event)
+	if err != nil {
+		return nil, fmt.Errorf("repository path failed. error: %v", err)
+	}
+
+	return &Match{
+		Index:         i,
+		Config:        &registryConfig,
+		Environment:   environment,
+		RepositoryDir: repositoryDir,
+	}, nil
+}
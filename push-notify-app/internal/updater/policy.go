@@ -0,0 +1,94 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+var (
+	ErrImageTagDowngrade = errors.New("image tag downgrade")
+)
+
+type SemVerPolicy struct {
+	// 更新してよいバージョンの範囲
+	// e.g. >=1.2.0 <2.0.0
+	// e.g. ~1.4
+	Constraint string `yaml:"constraint"`
+	// trueの場合はprereleaseのタグ(e.g. 1.3.0-rc.1)も更新の対象にします
+	// constraintを指定する場合、prereleaseのタグはconstraintの全ての条件がprereleaseを含むときだけ満たします
+	// e.g. >=1.3.0-0 <2.0.0-0
+	AllowPrerelease bool `yaml:"allowPrerelease"`
+	// trueの場合はマニフェストのバージョンより低いタグでも更新します
+	AllowDowngrade bool `yaml:"allowDowngrade"`
+}
+
+// allowTag はタグを更新の対象にするかを返します
+// semverだけを指定している場合はallowImageTagは見ません
+func (c *RegistryConfig) allowTag(tag string) bool {
+	if c.SemVer != nil && c.AllowImageTag == "" {
+		return true
+	}
+
+	return c.checkAllowTag(tag)
+}
+
+// checkSemVer はタグがsemverのポリシーを満たしているかを確認します
+func (c *RegistryConfig) checkSemVer(tag string) error {
+	if c.SemVer == nil {
+		return nil
+	}
+
+	version, err := semver.NewVersion(tag)
+	if err != nil {
+		return fmt.Errorf("tag is not semver. tag: %s", tag)
+	}
+
+	if version.Prerelease() != "" && !c.SemVer.AllowPrerelease {
+		return fmt.Errorf("prerelease is not allowed. tag: %s", tag)
+	}
+
+	if c.SemVer.Constraint == "" {
+		return nil
+	}
+
+	constraint, err := semver.NewConstraint(c.SemVer.Constraint)
+	if err != nil {
+		return fmt.Errorf("invalid semver constraint. constraint: %s error: %v", c.SemVer.Constraint, err)
+	}
+
+	// 1.2.0-rc.1 は1.2.0より前のバージョンなので、>=1.2.0 は満たさない
+	if !constraint.Check(version) {
+		return fmt.Errorf("tag does not satisfy constraint. tag: %s constraint: %s", tag, c.SemVer.Constraint)
+	}
+
+	return nil
+}
+
+// checkDowngrade はマニフェストのタグより低いバージョンに更新しようとしていないかを確認します
+// マニフェストのタグがsemverでない場合は比較しません
+func (c *RegistryConfig) checkDowngrade(oldTag, newTag string) error {
+	if c.SemVer == nil || c.SemVer.AllowDowngrade || oldTag == "" {
+		return nil
+	}
+
+	// digestで固定している場合 (e.g. v1.2.0@sha256:...) はタグの部分で比較する
+	oldTag, _, _ = strings.Cut(oldTag, "@")
+	current, err := semver.NewVersion(oldTag)
+	if err != nil {
+		return nil
+	}
+
+	next, err := semver.NewVersion(newTag)
+	if err != nil {
+		return nil
+	}
+
+	if next.LessThan(current) {
+		return fmt.Errorf("%w. current: %s new: %s", ErrImageTagDowngrade, oldTag, newTag)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+package updater
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckSemVer(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  SemVerPolicy
+		tag     string
+		wantErr bool
+	}{
+		{
+			name:   "constraintを満たす",
+			policy: SemVerPolicy{Constraint: ">=1.2.0 <2.0.0"},
+			tag:    "v1.4.0",
+		},
+		{
+			name:    "constraintを満たさない",
+			policy:  SemVerPolicy{Constraint: ">=1.2.0 <2.0.0"},
+			tag:     "2.0.0",
+			wantErr: true,
+		},
+		{
+			name:    "semverでない",
+			policy:  SemVerPolicy{},
+			tag:     "latest",
+			wantErr: true,
+		},
+		{
+			name:    "prereleaseを許可していない",
+			policy:  SemVerPolicy{},
+			tag:     "1.3.0-rc.1",
+			wantErr: true,
+		},
+		{
+			name:   "prereleaseを許可している",
+			policy: SemVerPolicy{AllowPrerelease: true},
+			tag:    "1.3.0-rc.1",
+		},
+		{
+			name:    "prereleaseはリリースより前のバージョンとして比較する",
+			policy:  SemVerPolicy{Constraint: ">=1.2.0", AllowPrerelease: true},
+			tag:     "1.2.0-rc.1",
+			wantErr: true,
+		},
+		{
+			name:   "prereleaseを含むconstraintを満たす",
+			policy: SemVerPolicy{Constraint: ">=1.3.0-0 <2.0.0-0", AllowPrerelease: true},
+			tag:    "1.3.0-rc.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &RegistryConfig{SemVer: &tt.policy}
+			if err := config.checkSemVer(tt.tag); (err != nil) != tt.wantErr {
+				t.Errorf("checkSemVer(%s) error = %v, wantErr %v", tt.tag, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckDowngrade(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  SemVerPolicy
+		oldTag  string
+		newTag  string
+		wantErr bool
+	}{
+		{
+			name:   "バージョンが上がる",
+			oldTag: "1.2.0",
+			newTag: "1.3.0",
+		},
+		{
+			name:    "バージョンが下がる",
+			oldTag:  "1.3.0",
+			newTag:  "1.2.0",
+			wantErr: true,
+		},
+		{
+			name:    "digestで固定しているタグと比較する",
+			oldTag:  "1.3.0@sha256:0123",
+			newTag:  "1.3.0-rc.1",
+			wantErr: true,
+		},
+		{
+			name:   "ダウングレードを許可している",
+			policy: SemVerPolicy{AllowDowngrade: true},
+			oldTag: "1.3.0",
+			newTag: "1.2.0",
+		},
+		{
+			name:   "今のタグがsemverでない場合は比較しない",
+			oldTag: "latest",
+			newTag: "1.2.0",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &RegistryConfig{SemVer: &tt.policy}
+			err := config.checkDowngrade(tt.oldTag, tt.newTag)
+			if tt.wantErr != errors.Is(err, ErrImageTagDowngrade) || (!tt.wantErr && err != nil) {
+				t.Errorf("checkDowngrade(%s, %s) error = %v, wantErr %v", tt.oldTag, tt.newTag, err, tt.wantErr)
+			}
+		})
+	}
+}
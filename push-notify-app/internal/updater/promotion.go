@@ -0,0 +1,227 @@
+package updater
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+type PromotionGate string
+
+const (
+	// PromotionGateAuto はPRがマージされたらすぐに次の環境へ昇格します
+	PromotionGateAuto PromotionGate = "auto"
+	// PromotionGateLabel はマージされたPRにラベルが付いている、または後から付けられた場合に昇格します
+	PromotionGateLabel PromotionGate = "label"
+	// PromotionGateSoak はPRがマージされてから一定時間経ったら昇格します
+	PromotionGateSoak PromotionGate = "soak"
+)
+
+const defaultPromotionLabel = "promote"
+
+type PromotionStage struct {
+	// e.g. dev
+	From string `yaml:"from"`
+	// e.g. staging
+	To string `yaml:"to"`
+	// optional
+	// auto (default), label, soak
+	Gate PromotionGate `yaml:"gate"`
+	// optional
+	// gateがlabelの場合のラベル
+	// default: promote
+	Label string `yaml:"label"`
+	// gateがsoakの場合にマージから待つ時間
+	// e.g. 1h
+	Soak time.Duration `yaml:"soak"`
+}
+
+// promotionMarker はPRの本文に埋め込む昇格用の情報です
+// マージされたときにどのイメージをどの環境に出したかを復元するために使います
+// e.g. <!-- image-committer:promotion {"registry":"...","environment":"dev",...} -->
+var promotionMarkerPattern = regexp.MustCompile(`<!-- image-committer:promotion (\{.*?\}) -->`)
+
+func promotionMarker(c *change) string {
+	event := *c.event
+	event.Environment = c.environment
+
+	data, err := json.Marshal(&event)
+	if err != nil {
+		return ""
+	}
+
+	return fmt.Sprintf("<!-- image-committer:promotion %s -->", data)
+}
+
+// parsePromotionMarkers はPRの本文から更新したイメージと環境を取り出します
+func parsePromotionMarkers(body string) []*model.ImageEvent {
+	var events []*model.ImageEvent
+	for _, match := range promotionMarkerPattern.FindAllStringSubmatch(body, -1) {
+		var event model.ImageEvent
+		if err := json.Unmarshal([]byte(match[1]), &event); err != nil {
+			continue
+		}
+		events = append(events, &event)
+	}
+
+	return events
+}
+
+// nextStage はイベントの環境から次の環境への昇格の設定を返します
+func (c *RegistryConfig) nextStage(environment string) (*PromotionStage, bool) {
+	for i := range c.Promotion {
+		if c.Promotion[i].From == environment {
+			return &c.Promotion[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Promoter はマージされた更新PRを受け取り、昇格の設定に従って次の環境を更新します
+// soakの待ち時間はメモリ上で管理するため、再起動すると待っている昇格は失われます
+type Promoter struct {
+	configs func() []RegistryConfig
+	handle  func(ctx context.Context, event *model.ImageEvent) error
+
+	mu     sync.Mutex
+	timers map[string]*time.Timer
+}
+
+func NewPromoter(configs func() []RegistryConfig, handle func(ctx context.Context, event *model.ImageEvent) error) *Promoter {
+	return &Promoter{
+		configs: configs,
+		handle:  handle,
+		timers:  make(map[string]*time.Timer),
+	}
+}
+
+// HandlePullRequest はpull_requestイベントから次の環境への昇格を開始します
+// マージされていないPRや、このアプリが作っていないPRは無視します
+func (p *Promoter) HandlePullRequest(ctx context.Context, event *model.GitHubPullRequestEvent) error {
+	pr := &event.PullRequest
+	if !pr.Merged {
+		return nil
+	}
+
+	// 本文の情報は誰でも書けるため、このアプリが作ったブランチからのPRだけを信用する
+	if !strings.HasPrefix(pr.Head.Ref, branchPrefix) {
+		log.Debug(ctx, "ignore pull request not created by image updater. pr: %s head: %s", pr.HTMLURL, pr.Head.Ref)
+		return nil
+	}
+
+	switch event.Action {
+	case model.GitHubPullRequestActionClosed, model.GitHubPullRequestActionLabeled:
+	default:
+		return nil
+	}
+
+	for _, deployed := range parsePromotionMarkers(pr.Body) {
+		config, ok := filterRegistryConfigs(deployed, p.configs())
+		if !ok {
+			log.Debug(ctx, "promotion config not found. event: %v environment: %s", deployed, deployed.Environment)
+			continue
+		}
+
+		stage, ok := config.nextStage(deployed.Environment)
+		if !ok {
+			continue
+		}
+
+		next := *deployed
+		next.Environment = stage.To
+		next.Source.Type = model.SourcePromotion
+		next.Source.ID = pr.HTMLURL
+
+		if err := p.promote(ctx, event, stage, &next); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Promoter) promote(ctx context.Context, event *model.GitHubPullRequestEvent, stage *PromotionStage, next *model.ImageEvent) error {
+	pr := &event.PullRequest
+
+	switch stage.Gate {
+	case "", PromotionGateAuto:
+		if event.Action != model.GitHubPullRequestActionClosed {
+			return nil
+		}
+	case PromotionGateLabel:
+		label := stage.Label
+		if label == "" {
+			label = defaultPromotionLabel
+		}
+
+		// マージ時に付いていた場合はclosed、マージ後に付けた場合はlabeledで昇格する
+		if event.Action == model.GitHubPullRequestActionClosed && !pr.HasLabel(label) {
+			log.Info(ctx, "promotion waiting for label. pr: %s label: %s", pr.HTMLURL, label)
+			return nil
+		}
+		if event.Action == model.GitHubPullRequestActionLabeled && (event.Label == nil || event.Label.Name != label) {
+			return nil
+		}
+	case PromotionGateSoak:
+		if event.Action != model.GitHubPullRequestActionClosed {
+			return nil
+		}
+
+		p.soak(ctx, pr, stage, next)
+		return nil
+	default:
+		return fmt.Errorf("unknown promotion gate. gate: %s", stage.Gate)
+	}
+
+	log.Info(ctx, "promoting image. image: %s:%s %s -> %s pr: %s", next.Reference(), next.Tag, stage.From, stage.To, pr.HTMLURL)
+	return p.handle(ctx, next)
+}
+
+// soak はマージからstage.Soakが経ったら昇格します
+func (p *Promoter) soak(ctx context.Context, pr *model.GitHubPullRequest, stage *PromotionStage, next *model.ImageEvent) {
+	key := fmt.Sprintf("%s:%s:%s", next.Reference(), next.Tag, next.Environment)
+
+	wait := stage.Soak
+	if mergedAt, err := time.Parse(time.RFC3339, pr.MergedAt); err == nil {
+		wait -= time.Since(mergedAt)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// webhookの再送で二重に待たない
+	if _, ok := p.timers[key]; ok {
+		return
+	}
+
+	log.Info(ctx, "promotion soaking. image: %s:%s %s -> %s wait: %s", next.Reference(), next.Tag, stage.From, stage.To, wait)
+	p.timers[key] = time.AfterFunc(wait, func() {
+		p.mu.Lock()
+		delete(p.timers, key)
+		p.mu.Unlock()
+
+		log.Info(ctx, "promoting image. image: %s:%s %s -> %s pr: %s", next.Reference(), next.Tag, stage.From, stage.To, pr.HTMLURL)
+		if err := p.handle(ctx, next); err != nil {
+			log.Error(ctx, "failed to promote image. image: %s:%s error: %v", next.Reference(), next.Tag, err)
+		}
+	})
+}
+
+// Stop は待っている昇格を全て止めます
+func (p *Promoter) Stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, timer := range p.timers {
+		timer.Stop()
+		delete(p.timers, key)
+	}
+}
@@ -0,0 +1,67 @@
+package updater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+func TestPromoterHandlePullRequest(t *testing.T) {
+	config := RegistryConfig{
+		RegitryURI: "registry.example.com:5000/$1/$2",
+		Env:        map[string]string{"registry.example.com:5000": "dev"},
+		Promotion:  []PromotionStage{{From: "dev", To: "staging"}},
+	}
+	marker := promotionMarker(&change{
+		event: &model.ImageEvent{
+			Registry:   "registry.example.com:5000",
+			Repository: "team/app",
+			Tag:        "v2",
+		},
+		environment: "dev",
+	})
+
+	tests := []struct {
+		name    string
+		head    string
+		wantEnv []string
+	}{
+		{
+			name:    "このアプリのブランチからのPRは昇格する",
+			head:    "image_updater_app_dev_v2",
+			wantEnv: []string{"staging"},
+		},
+		{
+			name: "このアプリのブランチ以外からのPRは本文に情報があっても無視する",
+			head: "feature/promote",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var environments []string
+			promoter := NewPromoter(func() []RegistryConfig { return []RegistryConfig{config} }, func(_ context.Context, event *model.ImageEvent) error {
+				environments = append(environments, event.Environment)
+				return nil
+			})
+			defer promoter.Stop()
+
+			err := promoter.HandlePullRequest(context.Background(), &model.GitHubPullRequestEvent{
+				Action: model.GitHubPullRequestActionClosed,
+				PullRequest: model.GitHubPullRequest{
+					HTMLURL: "https://github.com/murasame29/manifests/pull/1",
+					Body:    "## Image Update\n\n" + marker + "\n",
+					Merged:  true,
+					Head:    model.GitHubPullRequestHead{Ref: tt.head},
+				},
+			})
+			if err != nil {
+				t.Fatalf("HandlePullRequest() error = %v", err)
+			}
+			if len(environments) != len(tt.wantEnv) || (len(environments) != 0 && environments[0] != tt.wantEnv[0]) {
+				t.Errorf("promoted environments = %v, want %v", environments, tt.wantEnv)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package updater
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigStore は設定ファイルから読み込んだ設定を保持し、ファイルが変わったら入れ替えます
+// 新しい設定が不正な場合は今の設定を使い続けます
+type ConfigStore struct {
+	path    string
+	configs atomic.Pointer[[]RegistryConfig]
+
+	// mu はReloadを直列化します
+	mu   sync.Mutex
+	hash [sha256.Size]byte
+	// invalid は最後に検証に失敗した内容のhashです。同じ内容で何度もエラーを出さないために使います
+	invalid [sha256.Size]byte
+}
+
+func NewConfigStore(path string) (*ConfigStore, error) {
+	s := &ConfigStore{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := parseConfigData(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config. path: %s\n%w", path, err)
+	}
+
+	s.hash = sha256.Sum256(data)
+	s.configs.Store(&configs)
+
+	return s, nil
+}
+
+// Get は今の設定を返します。返した設定は書き換えないでください
+func (s *ConfigStore) Get() []RegistryConfig {
+	return *s.configs.Load()
+}
+
+// Reload は設定ファイルを読み直し、内容が変わっていれば検証してから入れ替えます
+func (s *ConfigStore) Reload(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read config. path: %s error: %v", s.path, err)
+	}
+
+	hash := sha256.Sum256(data)
+	if hash == s.hash || hash == s.invalid {
+		log.Debug(ctx, "config not changed. path: %s", s.path)
+		return nil
+	}
+
+	configs, err := parseConfigData(data)
+	if err != nil {
+		s.invalid = hash
+		return fmt.Errorf("invalid config. keep current config. path: %s\n%w", s.path, err)
+	}
+
+	current := s.Get()
+	s.hash = hash
+	s.configs.Store(&configs)
+
+	log.Info(ctx, "config reloaded. path: %s rules: %d", s.path, len(configs))
+	for _, line := range diffConfigs(current, configs) {
+		log.Info(ctx, "config changed. %s", line)
+	}
+
+	return nil
+}
+
+// Watch はctxがキャンセルされるまでintervalごとに設定ファイルを確認します
+// ConfigMapのファイルはsymlinkの張り替えで更新されるため、inotifyではなく内容のhashで変更を検知します
+func (s *ConfigStore) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(ctx); err != nil {
+				log.Error(ctx, "failed to reload config. error: %v", err)
+			}
+		}
+	}
+}
+
+// diffConfigs は変わったルールをregistryURIごとに返します
+// e.g. added: ghcr.io/murasame29/$1
+// e.g. changed: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/*/$1/$2/$3 (ap-northeast-1) fields: allowImageTag, env
+func diffConfigs(current, next []RegistryConfig) []string {
+	currentRules := indexConfigs(current)
+	nextRules := indexConfigs(next)
+
+	var lines []string
+	for _, key := range configKeys(current) {
+		if _, ok := nextRules[key]; !ok {
+			lines = append(lines, "removed: "+key)
+		}
+	}
+
+	for _, key := range configKeys(next) {
+		before, ok := currentRules[key]
+		if !ok {
+			lines = append(lines, "added: "+key)
+			continue
+		}
+
+		if fields := diffFields(before, nextRules[key]); len(fields) != 0 {
+			lines = append(lines, fmt.Sprintf("changed: %s fields: %s", key, strings.Join(fields, ", ")))
+		}
+	}
+
+	// 最初に一致したルールを使うため、順番が変わった場合も出す
+	if len(lines) == 0 && !reflect.DeepEqual(configKeys(current), configKeys(next)) {
+		lines = append(lines, "rule order changed")
+	}
+
+	return lines
+}
+
+// configKeys はルールを区別するキーを設定の順番で返します
+// 同じregistryURIとregionのルールが複数ある場合は2つ目以降に#2, #3 ..を付けます
+func configKeys(configs []RegistryConfig) []string {
+	keys := make([]string, 0, len(configs))
+	seen := make(map[string]int)
+	for i := range configs {
+		key := configs[i].RegitryURI
+		if configs[i].Region != "" {
+			key += " (" + configs[i].Region + ")"
+		}
+
+		seen[key]++
+		if seen[key] > 1 {
+			key = fmt.Sprintf("%s #%d", key, seen[key])
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func indexConfigs(configs []RegistryConfig) map[string]*RegistryConfig {
+	index := make(map[string]*RegistryConfig, len(configs))
+	for i, key := range configKeys(configs) {
+		index[key] = &configs[i]
+	}
+
+	return index
+}
+
+// diffFields は値が変わった設定のキー(e.g. allowImageTag)を返します
+func diffFields(current, next *RegistryConfig) []string {
+	before := configFields(current)
+	after := configFields(next)
+
+	var fields []string
+	for _, item := range after {
+		key := fmt.Sprint(item.Key)
+		if !reflect.DeepEqual(fieldValue(before, key), item.Value) {
+			fields = append(fields, key)
+		}
+	}
+
+	return fields
+}
+
+func configFields(config *RegistryConfig) yaml.MapSlice {
+	var fields yaml.MapSlice
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return nil
+	}
+	if err := yaml.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+
+	return fields
+}
+
+func fieldValue(fields yaml.MapSlice, key string) interface{} {
+	for _, item := range fields {
+		if fmt.Sprint(item.Key) == key {
+			return item.Value
+		}
+	}
+
+	return nil
+}
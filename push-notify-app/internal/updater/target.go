@@ -0,0 +1,207 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/yamledit"
+	"gopkg.in/yaml.v3"
+)
+
+type TargetType string
+
+const (
+	TargetKustomize TargetType = "kustomize"
+	TargetHelm      TargetType = "helm"
+	TargetManifest  TargetType = "manifest"
+	TargetArgoCD    TargetType = "argocd"
+	TargetFlux      TargetType = "flux"
+)
+
+type TargetConfig struct {
+	// optional
+	// kustomize (default), helm, manifest
+	// manifest: GitHubRepositoryのディレクトリ直下にあるマニフェストのcontainers/initContainersを直接更新します (サブディレクトリは見ません)
+	// argocd: Applicationのspec.source.kustomize.imagesかspec.source.helm.parametersを更新します
+	// flux: HelmReleaseのspec.valuesを更新します
+	Type TargetType `yaml:"type"`
+	// optional
+	// GitHubRepositoryのディレクトリからの相対パス。$envは環境名に置き換えます
+	// default: kustomization.yaml (kustomize), values-$env.yaml (helm), application.yaml (argocd), helmrelease.yaml (flux)
+	// e.g. values-$env.yaml
+	File string `yaml:"file"`
+	// optional
+	// helm, fluxの場合はvaluesの中の書き換えるタグのパス
+	// argocdの場合はhelm.parametersの書き換えるパラメータ名
+	// default: .image.tag
+	// e.g. .image.tag
+	// e.g. .sidecars[name=proxy].image.tag
+	Paths []string `yaml:"paths"`
+	// optional
+	// argocd, fluxの場合に更新するリソースのmetadata.name 指定がない場合はファイルの最初のリソースを更新します
+	// e.g. sample-app-dev
+	Name string `yaml:"name"`
+}
+
+// targetType は更新対象の種類を返します。指定がない場合はkustomizeです
+func (c *RegistryConfig) targetType() TargetType {
+	if c.Target == nil || c.Target.Type == "" {
+		return TargetKustomize
+	}
+
+	return c.Target.Type
+}
+
+// targetFile は更新するファイルのGitHubRepositoryのディレクトリからの相対パスを返します
+func (c *RegistryConfig) targetFile(environment string) string {
+	file := ""
+	if c.Target != nil {
+		file = c.Target.File
+	}
+
+	if file == "" {
+		switch c.targetType() {
+		case TargetHelm:
+			file = "values-$env.yaml"
+		case TargetArgoCD:
+			file = "application.yaml"
+		case TargetFlux:
+			file = "helmrelease.yaml"
+		default:
+			file = "kustomization.yaml"
+		}
+	}
+
+	return strings.ReplaceAll(file, "$env", environment)
+}
+
+// updateTarget は設定の種類に合わせてファイルを更新し、更新したファイルのリポジトリからの相対パスを返します
+func updateTarget(ctx context.Context, root string, c *change) ([]string, error) {
+	var path string
+	var err error
+	switch c.config.targetType() {
+	case TargetKustomize:
+		path, err = updateKustomization(root, c)
+	case TargetHelm:
+		path, err = updateHelmValues(root, c)
+	case TargetManifest:
+		return updateManifests(ctx, root, c)
+	case TargetArgoCD:
+		path, err = updateArgoCDApplication(root, c)
+	case TargetFlux:
+		path, err = updateFluxHelmRelease(root, c)
+	default:
+		return nil, fmt.Errorf("unknown target type. type: %s", c.config.targetType())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{path}, nil
+}
+
+// valuePaths はvaluesの中の書き換えるタグのパスを返します
+func (c *RegistryConfig) valuePaths() []string {
+	if c.Target == nil || len(c.Target.Paths) == 0 {
+		return []string{".image.tag"}
+	}
+
+	return c.Target.Paths
+}
+
+// findResource はファイルの中からkindとmetadata.nameが一致するリソースを返します
+// nameが空の場合はkindが一致する最初のリソースを返します
+func findResource(file *yamledit.File, kind, name string) *yaml.Node {
+	for _, document := range file.Documents {
+		if k := yamledit.Lookup(document, "kind"); k == nil || k.Value != kind {
+			continue
+		}
+
+		if name == "" {
+			return document
+		}
+
+		if n, _ := yamledit.Resolve(document, ".metadata.name"); n != nil && n.Value == name {
+			return document
+		}
+	}
+
+	return nil
+}
+
+// errParseFile はファイルをYAMLとして読めない場合のエラーです
+var errParseFile = errors.New("failed to parse file")
+
+// editFile はリポジトリのファイルをYAMLとして読み込んでeditで編集し、変更があれば書き戻します
+// 書き戻す前の内容はrollbackのために残します
+func (c *change) editFile(root, path string, edit func(file *yamledit.File) error) error {
+	filePath := filepath.Join(root, path)
+
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to get stat. path: %s error: %v", path, err)
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file. path: %s error: %v", path, err)
+	}
+
+	file, err := yamledit.Parse(data)
+	if err != nil {
+		return fmt.Errorf("%w. path: %s error: %v", errParseFile, path, err)
+	}
+
+	if err := edit(file); err != nil {
+		return err
+	}
+
+	// 同じ内容になる場合は書き込まない
+	content := file.Bytes()
+	if !file.Changed() || bytes.Equal(content, data) {
+		return nil
+	}
+
+	if _, ok := c.backups[path]; !ok {
+		if c.backups == nil {
+			c.backups = make(map[string][]byte)
+		}
+		c.backups[path] = data
+	}
+
+	if err := os.WriteFile(filePath, content, stat.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write file. path: %s error: %v", path, err)
+	}
+
+	return nil
+}
+
+// changed はeditFileでファイルの内容を変えたかを返します
+func (c *change) changed() bool {
+	return len(c.backups) != 0
+}
+
+// rollback はeditFileで書き換えたファイルを元の内容に戻します
+func (c *change) rollback(root string) error {
+	for path, data := range c.backups {
+		filePath := filepath.Join(root, path)
+
+		stat, err := os.Stat(filePath)
+		if err != nil {
+			return fmt.Errorf("failed to get stat. path: %s error: %v", path, err)
+		}
+
+		if err := os.WriteFile(filePath, data, stat.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write file. path: %s error: %v", path, err)
+		}
+	}
+	c.backups = nil
+
+	return nil
+}
@@ -2,18 +2,16 @@ package updater
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/murasame29/image-registry-push-notify/sample-app/internal/git"
 	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
 	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
-	"gopkg.in/yaml.v2"
-	"sigs.k8s.io/kustomize/api/types"
 )
 
 var (
@@ -22,112 +20,337 @@ var (
 	ErrDuplicatePR        = errors.New("duplicate pr")
 )
 
-func Update(ctx context.Context, config *AppConfig, event *model.ECRPushEvent) error {
-	return update(ctx, config, event)
+// change はイベント1件分の更新内容です
+type change struct {
+	event       *model.ImageEvent
+	config      *RegistryConfig
+	environment string
+
+	// e.g. https://github.com/murasame29/image-registry-push-notify
+	cloneURL   string
+	owner      string
+	repository string
+	// repositoryDir はGitHubRepositoryの変数を展開したものです
+	// e.g. github.com/murasame29/image-registry-push-notify/services/sample/sample-app/app/overlays/dev
+	repositoryDir string
+	// path はリポジトリ内の更新するディレクトリです
+	// e.g. services/sample/sample-app/app/overlays/dev
+	path string
+
+	// oldTag は更新前のタグです。更新処理の中で設定されます
+	oldTag string
+	// err は更新できなかった場合のエラーです。更新処理の中で設定され、エラーのある変更はコミットしません
+	err error
+	// backups は書き換える前のファイルの内容です。失敗した変更を戻すために使います
+	backups map[string][]byte
 }
-func update(ctx context.Context, config *AppConfig, event *model.ECRPushEvent) error {
-	github, err := git.NewGitHub(config.GitHubApplicationID, config.GitHubAppInstallationID, config.GitHubUsername, config.GitHubAppCrtPath)
+
+// Update はイベントに対応するマニフェストを更新してPRを作成し、そのURLを返します
+func Update(ctx context.Context, config *AppConfig, event *model.ImageEvent) (string, error) {
+	c, err := resolve(ctx, config, event)
 	if err != nil {
-		return fmt.Errorf("failed to new github. error: %v", err)
+		return "", err
+	}
+
+	pullRequestURL, err := update(ctx, config, []*change{c})
+	if c.err != nil {
+		return "", c.err
 	}
 
+	return pullRequestURL, err
+}
+
+// resolve はイベントに対応する設定を探し、更新内容を組み立てます
+// リポジトリへのアクセスはしません
+func resolve(ctx context.Context, config *AppConfig, event *model.ImageEvent) (*change, error) {
 	regitryConfig, err := config.parseConfig(event)
 	if err != nil {
-		return fmt.Errorf("failed to new github. error: %v", err)
+		return nil, fmt.Errorf("failed to parse config. error: %v", err)
 	}
 
-	if !regitryConfig.checkAllowTag(event.Detail.ImageTag) {
+	if !regitryConfig.allowTag(event.Tag) {
 		log.Warn(ctx, "image tag not allowed. event: %v", event)
-		return ErrImageTagNotAllowed
+		return nil, ErrImageTagNotAllowed
 	}
 
-	if !regitryConfig.checkDenyTag(event.Detail.ImageTag) {
+	if err := regitryConfig.checkSemVer(event.Tag); err != nil {
+		log.Warn(ctx, "image tag not allowed. event: %v error: %v", event, err)
+		return nil, ErrImageTagNotAllowed
+	}
+
+	if !regitryConfig.checkDenyTag(event.Tag) {
 		log.Warn(ctx, "image tag deny. event: %v", event)
-		return ErrImageTagDeny
+		return nil, ErrImageTagDeny
 	}
 
+	environment, _ := regitryConfig.environment(event)
+
 	repositoryDir, err := regitryConfig.buildRepositoryName(event)
 	if err != nil {
-		return fmt.Errorf("repository path failed. error: %v", err)
+		return nil, fmt.Errorf("repository path failed. error: %v", err)
 	}
 
-	repoURI := strings.Split(regitryConfig.GitHubRepository, "/")
-	repo, filePath, err := github.Clone(ctx, strings.Join(repoURI[:5], "/"))
+	owner, repository, path, err := splitGitHubRepository(repositoryDir)
 	if err != nil {
-		return fmt.Errorf("failed to clone repository. error: %v", err)
+		return nil, err
 	}
 
-	defer os.RemoveAll(filePath) // error: no check
+	return &change{
+		event:         event,
+		config:        regitryConfig,
+		environment:   environment,
+		cloneURL:      fmt.Sprintf("https://%s/%s/%s", githubHost, owner, repository),
+		owner:         owner,
+		repository:    repository,
+		repositoryDir: repositoryDir,
+		path:          path,
+	}, nil
+}
 
-	targetDir := filepath.Join(filePath, strings.Join(strings.Split(repositoryDir, "/")[5:], "/"), "kustomization.yaml")
+// update は同じリポジトリに対する変更をまとめて1つのコミットとPRにします
+// 変更ごとのエラーはchange.errに設定し、その変更を除いてコミットします
+// 全ての変更がエラーになった場合や、マニフェストが既に更新済みの場合は何もせずに空を返します
+func update(ctx context.Context, config *AppConfig, changes []*change) (string, error) {
+	github, err := git.NewGitHub(ctx, config.GitHubApplicationID, config.GitHubAppInstallationID, config.GitHubUsername, config.GitHubAppCrtPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to new github. error: %v", err)
+	}
 
-	kustomizationFile, err := os.Open(targetDir)
+	first := changes[0]
+
+	// 同じリポジトリへの更新が並行するとpushが競合するため直列化する
+	lockKey := first.cloneURL
+	if len(changes) == 1 && first.config.LockScope == LockScopePath {
+		lockKey = first.repositoryDir
+	}
+
+	log.Debug(ctx, "trying lock repository. key: %s", lockKey)
+	unlock, err := repositoryLocks.Lock(ctx, lockKey)
 	if err != nil {
-		return fmt.Errorf("failed to oepn kustomization.yaml dir. error: %v", err)
+		return "", fmt.Errorf("failed to lock repository. key: %s error: %v", lockKey, err)
+	}
+	defer unlock()
+
+	if config.CloneCache != nil {
+		github.UseCloneCache(config.CloneCache)
 	}
 
-	kustomizationData, err := io.ReadAll(kustomizationFile)
+	repo, filePath, release, err := github.Clone(ctx, first.cloneURL, cloneOptions(changes))
 	if err != nil {
-		return fmt.Errorf("failed to read file. error: %v", err)
+		return "", fmt.Errorf("failed to clone repository. error: %v", err)
 	}
 
-	var kustomization types.Kustomization
-	if err := yaml.Unmarshal(kustomizationData, &kustomization); err != nil {
-		return fmt.Errorf("failed to unmarshal kustomizatioin.yaml. error: %v", err)
+	defer release()
+
+	var (
+		applied []*change
+		paths   []string
+	)
+	for _, c := range changes {
+		changed, err := applyChange(ctx, filePath, c)
+		if err != nil {
+			// 戻せない場合は他の変更も正しくコミットできないため全体を失敗にする
+			if rollbackErr := c.rollback(filePath); rollbackErr != nil {
+				return "", fmt.Errorf("failed to rollback change. error: %v", rollbackErr)
+			}
+			c.err = err
+			continue
+		}
+
+		// 既に同じタグになっている場合(再配信やreplay)はコミットしない
+		if !c.changed() {
+			log.Info(ctx, "manifest already up to date. event: %v", c.event)
+			continue
+		}
+
+		applied = append(applied, c)
+		paths = append(paths, changed...)
 	}
 
-	imageURI := fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com/%s", event.Account, event.Region, event.Detail.RepositoryName)
-	image := findImage(kustomization.Images, imageURI)
+	if len(applied) == 0 {
+		return "", nil
+	}
+	changes = applied
 
-	if image == nil {
-		// .imagesがないから作る
-		kustomization.Images = append(kustomization.Images, types.Image{
-			Name:   imageURI,
-			NewTag: event.Detail.ImageTag,
-		})
-	} else {
-		image.NewTag = event.Detail.ImageTag
+	// ブランチ名は適用できた変更から決める。worktreeの変更はそのまま持っていく
+	branch := branchName(changes)
+	if err := github.Branch(ctx, repo, branch); err != nil {
+		return "", fmt.Errorf("faield to switch branch. error: %v", err)
 	}
 
-	newKustomization, err := yaml.Marshal(kustomization)
-	if err != nil {
-		return fmt.Errorf("failed to marshal kustomization. error: %v", err)
+	if dryRun(config, changes) {
+		return "", writeDryRun(ctx, config, github, repo, filePath, branch, changes, paths)
+	}
+
+	if _, err := github.Commit(ctx, repo, paths, commitMessage(changes)); err != nil {
+		return "", fmt.Errorf("failed to commit. error: %v", err)
+	}
+
+	if err := github.Push(ctx, repo); err != nil {
+		// 既にPRがある場合は無視 実装がきったないのは許容　wrapされてて比較できなかった
+		if !strings.Contains(err.Error(), git.ErrNonFastForwardUpdate.Error()) {
+			log.Error(ctx, "failed to push. error: %v", err)
+			return "", fmt.Errorf("failed to push. error: %v", err)
+		}
+
+		pullRequestURL, findErr := github.FindPullRequest(ctx, first.owner, first.repository, branch)
+		if findErr != nil {
+			return "", fmt.Errorf("failed to find pull request. error: %v", findErr)
+		}
+		if pullRequestURL != "" {
+			log.Warn(ctx, "failed to push. pull request already exists. url: %s error: %v", pullRequestURL, err)
+			return pullRequestURL, ErrDuplicatePR
+		}
+
+		// pushの後にPRの作成が失敗するとブランチだけが残る。今の内容で上書きしてPRを作り直す
+		log.Warn(ctx, "branch exists without pull request. overwrite branch. branch: %s", branch)
+		if err := github.ForcePush(ctx, repo, branch); err != nil {
+			log.Error(ctx, "failed to force push. error: %v", err)
+			return "", fmt.Errorf("failed to force push. error: %v", err)
+		}
 	}
 
-	stat, err := kustomizationFile.Stat()
+	pullRequestURL, err := github.CreatePullRequest(ctx, &git.PullRequest{
+		Owner:      first.owner,
+		Repository: first.repository,
+		Head:       branch,
+		Title:      pullRequestTitle(changes),
+		Body:       buildPullRequestBody(changes),
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get stat. error: %v", err)
+		return "", fmt.Errorf("failed to create pull request. error: %v", err)
 	}
 
-	if err := github.Branch(ctx, repo, fmt.Sprintf("image_updater_%s_%s_%s", strings.Join(strings.Split(event.Detail.RepositoryName, "/")[1:], "_"), regitryConfig.Env[event.Account], event.Detail.ImageTag)); err != nil {
-		return fmt.Errorf("faield to switch branch. error: %v", err)
+	return pullRequestURL, nil
+}
+
+// applyChange は1件分の変更をworktreeに書き込みます
+// 失敗した場合はc.rollbackで書き込んだファイルを戻してください
+func applyChange(ctx context.Context, root string, c *change) ([]string, error) {
+	paths, err := updateTarget(ctx, root, c)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := os.WriteFile(targetDir, newKustomization, stat.Mode().Perm()); err != nil {
-		return fmt.Errorf("failed to write file. error: %v", err)
+	if err := c.config.checkDowngrade(c.oldTag, c.event.Tag); err != nil {
+		log.Warn(ctx, "image tag downgrade. event: %v error: %v", c.event, err)
+		return nil, err
 	}
 
-	if _, err := github.Commit(ctx, repo, targetDir, fmt.Sprintf("[%s][image-committer][%s] イメージの更新 ", regitryConfig.Env[event.Account], event.Detail.RepositoryName)); err != nil {
-		return fmt.Errorf("failed to commit. error: %v", err)
+	// 内容が変わったファイルだけをコミットする
+	var changed []string
+	for _, path := range paths {
+		if _, ok := c.backups[path]; ok {
+			changed = append(changed, path)
+		}
 	}
 
-	if err := github.Push(context.Background(), repo); err != nil {
-		// 既にPRがある場合は無視 実装がきったないのは許容　wrapされてて比較できなかった
-		if strings.Contains(err.Error(), git.ErrNonFastForwardUpdate.Error()) {
-			log.Warn(ctx, "failed to push. error: %v", err)
-			return ErrDuplicatePR
+	return changed, nil
+}
+
+// cloneOptions は変更の設定からcloneのオプションを決めます
+// 履歴は一番多く必要な設定に合わせ、sparse checkoutは全ての変更がsparseを指定している場合だけ使います
+func cloneOptions(changes []*change) git.CloneOptions {
+	var opts git.CloneOptions
+	fullHistory := false
+	sparse := true
+	dirs := make([]string, 0, len(changes))
+	for _, c := range changes {
+		clone := c.config.Clone
+		if clone == nil {
+			clone = &CloneConfig{}
+		}
+
+		if clone.Depth == 0 {
+			fullHistory = true
+		} else if clone.Depth > opts.Depth {
+			opts.Depth = clone.Depth
+		}
+
+		if !clone.Sparse || c.path == "" {
+			sparse = false
 		}
-		log.Error(ctx, "failed to push. error: %v", err)
-		return fmt.Errorf("failed to push. error: %v", err)
+		dirs = append(dirs, c.path)
+	}
+
+	if fullHistory {
+		opts.Depth = 0
+	}
+	if sparse {
+		opts.SparseDirectories = dirs
+	}
+
+	return opts
+}
+
+// branchPrefix は更新用のブランチ名の接頭辞です。昇格ではこのブランチからのPRだけを扱います
+const branchPrefix = "image_updater_"
+
+// branchName は更新用のブランチ名を返します
+// まとめて更新する場合は内容から決まるハッシュを使い、同じ内容なら同じブランチになるようにします
+func branchName(changes []*change) string {
+	if len(changes) == 1 {
+		c := changes[0]
+		return fmt.Sprintf("%s%s_%s_%s", branchPrefix, strings.Join(strings.Split(c.event.Repository, "/")[1:], "_"), c.environment, c.event.Tag)
 	}
-	return nil
+
+	keys := make([]string, 0, len(changes))
+	for _, c := range changes {
+		keys = append(keys, fmt.Sprintf("%s:%s:%s", c.event.Reference(), c.event.Tag, c.environment))
+	}
+	sort.Strings(keys)
+
+	sum := sha256.Sum256([]byte(strings.Join(keys, ",")))
+	return fmt.Sprintf("%sbatch_%s", branchPrefix, hex.EncodeToString(sum[:])[:12])
+}
+
+func commitMessage(changes []*change) string {
+	if len(changes) == 1 {
+		c := changes[0]
+		return fmt.Sprintf("[%s][image-committer][%s] イメージの更新 ", c.environment, c.event.Repository)
+	}
+
+	var message strings.Builder
+	fmt.Fprintf(&message, "[image-committer] イメージの更新 (%d件)\n\n", len(changes))
+	for _, c := range changes {
+		fmt.Fprintf(&message, "- [%s] %s:%s\n", c.environment, c.event.Repository, c.event.Tag)
+	}
+
+	return message.String()
 }
 
-func findImage(images []types.Image, imageURI string) *types.Image {
-	for _, image := range images {
-		if image.Name == imageURI {
-			return &image
+func pullRequestTitle(changes []*change) string {
+	if len(changes) == 1 {
+		c := changes[0]
+		return fmt.Sprintf("[%s][image-committer][%s] イメージの更新 %s", c.environment, c.event.Repository, c.event.Tag)
+	}
+
+	return fmt.Sprintf("[image-committer] イメージの更新 (%d件)", len(changes))
+}
+
+// buildPullRequestBody はPRの本文を生成します
+func buildPullRequestBody(changes []*change) string {
+	var body strings.Builder
+	body.WriteString("## Image Update\n\n")
+	body.WriteString("| Image | Old Tag | New Tag | Digest | Account | Environment |\n")
+	body.WriteString("| --- | --- | --- | --- | --- | --- |\n")
+
+	for _, c := range changes {
+		oldTag := c.oldTag
+		if oldTag == "" {
+			oldTag = "-"
 		}
+
+		fmt.Fprintf(&body, "| `%s` | `%s` | `%s` | `%s` | `%s` | `%s` |\n",
+			c.event.Reference(), oldTag, c.event.Tag, c.event.Digest, c.event.Source.Account, c.environment)
+	}
+
+	// マージされたときに次の環境へ昇格するための情報
+	body.WriteString("\n")
+	for _, c := range changes {
+		body.WriteString(promotionMarker(c))
+		body.WriteString("\n")
 	}
-	return nil
+
+	return body.String()
 }
@@ -0,0 +1,162 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/model"
+)
+
+func TestApplyChangeRollback(t *testing.T) {
+	const input = `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: 1.0.0
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/worker
+  newTag: 1.0.0
+`
+	const want = `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: 1.1.0
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/worker
+  newTag: 1.0.0
+`
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "overlays", "dev")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	newChange := func(repository, tag string) *change {
+		return &change{
+			event: &model.ImageEvent{
+				Registry:   "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com",
+				Repository: repository,
+				Tag:        tag,
+			},
+			config:      &RegistryConfig{SemVer: &SemVerPolicy{}},
+			environment: "dev",
+			path:        "overlays/dev",
+		}
+	}
+
+	// workerはダウングレードになるため、appの変更だけを残す
+	changes := []*change{newChange("example/app", "1.1.0"), newChange("example/worker", "0.9.0")}
+	for _, c := range changes {
+		if _, err := applyChange(context.Background(), root, c); err != nil {
+			if err := c.rollback(root); err != nil {
+				t.Fatalf("rollback() error = %v", err)
+			}
+			c.err = err
+		}
+	}
+
+	if changes[0].err != nil {
+		t.Errorf("app error = %v", changes[0].err)
+	}
+	if !errors.Is(changes[1].err, ErrImageTagDowngrade) {
+		t.Errorf("worker error = %v, want %v", changes[1].err, ErrImageTagDowngrade)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "kustomization.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("kustomization.yaml\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestApplyChangeUnchanged(t *testing.T) {
+	const input = `images:
+- name: 123456789012.dkr.ecr.ap-northeast-1.amazonaws.com/example/app
+  newTag: 1.1.0
+`
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "overlays", "dev")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "kustomization.yaml"), []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 再配信などで同じタグのイベントを受け取った場合
+	c := &change{
+		event: &model.ImageEvent{
+			Registry:   "123456789012.dkr.ecr.ap-northeast-1.amazonaws.com",
+			Repository: "example/app",
+			Tag:        "1.1.0",
+		},
+		config:      &RegistryConfig{SemVer: &SemVerPolicy{}},
+		environment: "dev",
+		path:        "overlays/dev",
+	}
+
+	paths, err := applyChange(context.Background(), root, c)
+	if err != nil {
+		t.Fatalf("applyChange() error = %v", err)
+	}
+	if len(paths) != 0 || c.changed() {
+		t.Errorf("paths = %v, changed = %v, want no changes", paths, c.changed())
+	}
+}
+
+func TestSplitGitHubRepository(t *testing.T) {
+	tests := []struct {
+		name           string
+		input          string
+		wantOwner      string
+		wantRepository string
+		wantPath       string
+		wantErr        bool
+	}{
+		{
+			name:           "パスあり",
+			input:          "github.com/murasame29/image-registry-push-notify/services/sample/sample-app/app/overlays/dev",
+			wantOwner:      "murasame29",
+			wantRepository: "image-registry-push-notify",
+			wantPath:       "services/sample/sample-app/app/overlays/dev",
+		},
+		{
+			name:           "パスなし",
+			input:          "github.com/murasame29/manifests",
+			wantOwner:      "murasame29",
+			wantRepository: "manifests",
+		},
+		{
+			name:    "スキームを付けている",
+			input:   "https://github.com/murasame29/manifests/overlays/dev",
+			wantErr: true,
+		},
+		{
+			name:    "リポジトリ名がない",
+			input:   "github.com/murasame29",
+			wantErr: true,
+		},
+		{
+			name:    "github.com以外",
+			input:   "gitlab.com/murasame29/manifests",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owner, repository, path, err := splitGitHubRepository(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitGitHubRepository() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if owner != tt.wantOwner || repository != tt.wantRepository || path != tt.wantPath {
+				t.Errorf("splitGitHubRepository() = %s, %s, %s", owner, repository, path)
+			}
+		})
+	}
+}
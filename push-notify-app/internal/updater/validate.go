@@ -0,0 +1,181 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// placeholderPattern はgithubRepositoryやregistryURIの中の$1, $2 ..や$envです
+var placeholderPattern = regexp.MustCompile(`\$[0-9A-Za-z]+`)
+
+// ValidateConfig は設定の全てのルールを検証し、見つかった問題をまとめて返します
+func ValidateConfig(configs []RegistryConfig) error {
+	if len(configs) == 0 {
+		return errors.New("config has no rules")
+	}
+
+	var errs []error
+	for i := range configs {
+		for _, err := range configs[i].validate() {
+			errs = append(errs, fmt.Errorf("config[%d] (%s): %w", i, configs[i].RegitryURI, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (c *RegistryConfig) validate() []error {
+	var errs []error
+
+	if c.RegitryURI == "" {
+		errs = append(errs, errors.New("registryURI is required"))
+	}
+	if c.GitHubRepository == "" {
+		errs = append(errs, errors.New("githubRepository is required"))
+	} else if _, _, _, err := splitGitHubRepository(c.GitHubRepository); err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, validateTagRule("allowImageTag", c.AllowImageTag)...)
+	errs = append(errs, validateTagRule("denyImageTag", c.DenyImageTag)...)
+	errs = append(errs, c.validatePlaceholders()...)
+	errs = append(errs, c.validateEnv()...)
+
+	if c.SemVer != nil && c.SemVer.Constraint != "" {
+		if _, err := semver.NewConstraint(c.SemVer.Constraint); err != nil {
+			errs = append(errs, fmt.Errorf("invalid semver constraint. constraint: %s error: %v", c.SemVer.Constraint, err))
+		}
+	}
+
+	switch c.LockScope {
+	case "", LockScopeRepository, LockScopePath:
+	default:
+		errs = append(errs, fmt.Errorf("unknown lockScope. lockScope: %s", c.LockScope))
+	}
+
+	switch c.Pin {
+	case "", PinTag, PinDigest, PinBoth:
+	default:
+		errs = append(errs, fmt.Errorf("unknown pin. pin: %s", c.Pin))
+	}
+
+	// digestだけで固定するとマニフェストにタグが残らず、ダウングレードを確認できない
+	if c.Pin == PinDigest && c.SemVer != nil && !c.SemVer.AllowDowngrade {
+		errs = append(errs, errors.New("pin: digest cannot check semver downgrade. use pin: both or semver.allowDowngrade: true"))
+	}
+
+	if c.Clone != nil && c.Clone.Depth < 0 {
+		errs = append(errs, fmt.Errorf("clone.depth must not be negative. depth: %d", c.Clone.Depth))
+	}
+
+	if c.Target != nil {
+		switch c.Target.Type {
+		case "", TargetKustomize, TargetHelm, TargetManifest, TargetArgoCD, TargetFlux:
+		default:
+			errs = append(errs, fmt.Errorf("unknown target.type. type: %s", c.Target.Type))
+		}
+	}
+
+	for _, stage := range c.Promotion {
+		errs = append(errs, stage.validate()...)
+	}
+
+	return errs
+}
+
+// validateTagRule はallowImageTag, denyImageTagの書式と正規表現を検証します
+// e.g. latest
+// e.g. regexp:^[0-9a-f]{7,40}$
+func validateTagRule(field, rule string) []error {
+	if rule == "" {
+		return nil
+	}
+
+	tags := strings.Split(rule, ":")
+	switch {
+	case len(tags) == 1:
+		return nil
+	case len(tags) > 2:
+		return []error{fmt.Errorf("%s must not contain ':' except after regexp. %s: %s", field, field, rule)}
+	case !strings.Contains(tags[0], "regexp"):
+		return []error{fmt.Errorf("%s has unknown prefix. use regexp:<pattern>. %s: %s", field, field, rule)}
+	}
+
+	if _, err := regexp.Compile(strings.TrimSpace(tags[1])); err != nil {
+		return []error{fmt.Errorf("%s has invalid regexp. %s: %s error: %v", field, field, rule, err)}
+	}
+
+	return nil
+}
+
+// validatePlaceholders はgithubRepositoryの$1, $2 ..がregistryURIで決まるかを検証します
+func (c *RegistryConfig) validatePlaceholders() []error {
+	var errs []error
+
+	defined := make(map[string]bool)
+	for _, segment := range strings.Split(c.RegitryURI, "/") {
+		if !strings.Contains(segment, "$") {
+			continue
+		}
+
+		// matchReferenceは要素全体が$Nの場合だけ値を取り出す
+		if placeholderPattern.FindString(segment) != segment {
+			errs = append(errs, fmt.Errorf("placeholder must be a whole path segment in registryURI. segment: %s", segment))
+			continue
+		}
+		defined[segment] = true
+	}
+
+	for _, placeholder := range placeholderPattern.FindAllString(c.GitHubRepository, -1) {
+		if placeholder == "$env" || defined[placeholder] {
+			continue
+		}
+		errs = append(errs, fmt.Errorf("placeholder is not defined in registryURI. placeholder: %s githubRepository: %s", placeholder, c.GitHubRepository))
+	}
+
+	return errs
+}
+
+// validateEnv はイベントから環境を引けるかを検証します
+func (c *RegistryConfig) validateEnv() []error {
+	if len(c.Env) == 0 {
+		return []error{errors.New("env is required. e.g. 123456789012: dev")}
+	}
+
+	var errs []error
+	for key, environment := range c.Env {
+		if environment == "" {
+			errs = append(errs, fmt.Errorf("env has empty environment. key: %s", key))
+		}
+	}
+
+	return errs
+}
+
+func (s *PromotionStage) validate() []error {
+	var errs []error
+
+	if s.From == "" || s.To == "" {
+		errs = append(errs, fmt.Errorf("promotion requires from and to. from: %s to: %s", s.From, s.To))
+	}
+
+	switch s.Gate {
+	case "", PromotionGateAuto, PromotionGateLabel:
+	case PromotionGateSoak:
+		if s.Soak <= 0 {
+			errs = append(errs, fmt.Errorf("promotion soak must be positive. from: %s to: %s soak: %s", s.From, s.To, s.Soak))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown promotion gate. from: %s to: %s gate: %s", s.From, s.To, s.Gate))
+	}
+
+	if s.Gate != PromotionGateSoak && s.Soak != 0 {
+		errs = append(errs, fmt.Errorf("promotion soak is only used with gate soak. from: %s to: %s", s.From, s.To))
+	}
+
+	return errs
+}
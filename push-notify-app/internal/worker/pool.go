@@ -0,0 +1,120 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/murasame29/image-registry-push-notify/sample-app/internal/log"
+)
+
+var (
+	ErrQueueFull  = errors.New("worker queue is full")
+	ErrPoolClosed = errors.New("worker pool is closed")
+)
+
+// Job はworkerで実行する処理です
+// ctxはjobごとのタイムアウトが設定され、shutdownが間に合わない場合にキャンセルされます
+type Job func(ctx context.Context)
+
+// Pool は固定数のworkerでjobを実行します
+// queueが溢れた場合、Submitは待たずにErrQueueFullを返します
+type Pool struct {
+	jobs       chan Job
+	jobTimeout time.Duration
+
+	// ctx はworkerに引き渡すcontextです。受信側のcontextとは独立しているため、
+	// 受信を止めた後も実行中のjobは完了まで動きます
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewPool(ctx context.Context, workers, queueSize int, jobTimeout time.Duration) *Pool {
+	ctx, cancel := context.WithCancel(ctx)
+	p := &Pool{
+		jobs:       make(chan Job, queueSize),
+		jobTimeout: jobTimeout,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+
+	for i := range workers {
+		p.wg.Add(1)
+		go p.work(i)
+	}
+
+	return p
+}
+
+func (p *Pool) work(id int) {
+	defer p.wg.Done()
+
+	for job := range p.jobs {
+		p.run(id, job)
+	}
+}
+
+func (p *Pool) run(id int, job Job) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.jobTimeout)
+	defer cancel()
+
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error(ctx, "worker panic recovered. worker: %d panic: %v", id, r)
+		}
+	}()
+
+	now := time.Now()
+	log.Trace(ctx, "worker started job. worker: %d", id)
+	job(ctx)
+	log.Trace(ctx, "worker finished job. worker: %d duration: %d ms", id, time.Since(now).Milliseconds())
+}
+
+// Submit はjobをqueueに積みます
+func (p *Pool) Submit(job Job) error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Shutdown は新しいjobの受付を止め、queueに残っているjobを含めて全て完了するまで待ちます
+// ctxが先に終了した場合は実行中のjobのcontextをキャンセルしてエラーを返します
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		return fmt.Errorf("failed to drain worker pool. remaining: %d error: %w", len(p.jobs), ctx.Err())
+	}
+}
@@ -0,0 +1,143 @@
+package yamledit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// segment はパスの1要素です
+// e.g. sidecars[name=proxy] -> key: sidecars, selectors: [{field: name, value: proxy}]
+type segment struct {
+	key       string
+	selectors []selector
+}
+
+// selector はシーケンスの要素を選びます。fieldが空の場合はindexで選びます
+type selector struct {
+	index int
+	field string
+	value string
+}
+
+// parsePath はパスを要素に分解します
+// e.g. .image.tag
+// e.g. .sidecars[name=proxy].image.tag
+// e.g. .spec.template.spec.containers[0].image
+func parsePath(path string) ([]segment, error) {
+	if !strings.HasPrefix(path, ".") {
+		return nil, fmt.Errorf("path must start with '.'. path: %s", path)
+	}
+
+	var segments []segment
+	for _, part := range strings.Split(path[1:], ".") {
+		key, rest, _ := strings.Cut(part, "[")
+		current := segment{key: key}
+
+		for rest != "" {
+			expr, after, ok := strings.Cut(rest, "]")
+			if !ok {
+				return nil, fmt.Errorf("missing ']'. path: %s", path)
+			}
+
+			if field, value, ok := strings.Cut(expr, "="); ok {
+				current.selectors = append(current.selectors, selector{field: field, value: value})
+			} else {
+				index, err := strconv.Atoi(expr)
+				if err != nil {
+					return nil, fmt.Errorf("invalid selector. path: %s selector: %s", path, expr)
+				}
+				current.selectors = append(current.selectors, selector{index: index})
+			}
+
+			rest = strings.TrimPrefix(after, "[")
+			if after != "" && !strings.HasPrefix(after, "[") {
+				return nil, fmt.Errorf("invalid path. path: %s", path)
+			}
+		}
+
+		if current.key == "" && len(current.selectors) == 0 {
+			return nil, fmt.Errorf("empty path element. path: %s", path)
+		}
+		segments = append(segments, current)
+	}
+
+	return segments, nil
+}
+
+// Resolve はnodeからパスを辿ったノードを返します。見つからない場合はnilを返します
+func Resolve(node *yaml.Node, path string) (*yaml.Node, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolve(node, segments), nil
+}
+
+func resolve(node *yaml.Node, segments []segment) *yaml.Node {
+	for _, s := range segments {
+		if s.key != "" {
+			node = Lookup(node, s.key)
+		}
+
+		for _, sel := range s.selectors {
+			node = sel.apply(node)
+		}
+
+		if node == nil {
+			return nil
+		}
+	}
+
+	return node
+}
+
+func (s selector) apply(node *yaml.Node) *yaml.Node {
+	if node == nil || node.Kind != yaml.SequenceNode {
+		return nil
+	}
+
+	if s.field == "" {
+		if s.index < 0 || s.index >= len(node.Content) {
+			return nil
+		}
+		return node.Content[s.index]
+	}
+
+	for _, item := range node.Content {
+		if value := Lookup(item, s.field); value != nil && value.Value == s.value {
+			return item
+		}
+	}
+
+	return nil
+}
+
+// SetPath はnodeからパスを辿った値を書き換え、書き換える前の値を返します
+// 最後の要素のキーだけがない場合は親のmappingに追加します
+func (f *File) SetPath(node *yaml.Node, path, value string) (string, error) {
+	segments, err := parsePath(path)
+	if err != nil {
+		return "", err
+	}
+
+	if target := resolve(node, segments); target != nil {
+		old := target.Value
+		return old, f.SetScalar(target, value)
+	}
+
+	last := segments[len(segments)-1]
+	if len(last.selectors) != 0 {
+		return "", fmt.Errorf("path not found. path: %s", path)
+	}
+
+	parent := resolve(node, segments[:len(segments)-1])
+	if parent == nil || parent.Kind != yaml.MappingNode {
+		return "", fmt.Errorf("path not found. path: %s", path)
+	}
+
+	return "", f.SetField(parent, last.key, value)
+}
@@ -0,0 +1,534 @@
+package yamledit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File はYAMLファイルを値の単位で編集します
+// 変更は元のバイト列のうち該当する箇所だけを書き換えるため、コメントやキーの順番、インデントはそのまま残ります
+type File struct {
+	src []byte
+	// lines は各行の先頭のoffsetです
+	lines []int
+
+	// Documents は---で区切られたドキュメントごとのルートのノードです
+	Documents []*yaml.Node
+
+	edits []edit
+	// newline は最終行に改行を足したかどうかです
+	newline bool
+	// crlf は改行がCRLFかどうかです。追加する行の改行を合わせます
+	crlf bool
+}
+
+type edit struct {
+	start int
+	end   int
+	text  string
+	// value はスカラーの値を書き換える編集かどうかです
+	value bool
+}
+
+// Field はmappingに追加するキーと値です
+type Field struct {
+	Key   string
+	Value string
+	// Comment は行末のコメントです。空の場合は付けません
+	Comment string
+}
+
+func Parse(data []byte) (*File, error) {
+	f := &File{
+		src:   data,
+		lines: []int{0},
+	}
+	for i, b := range data {
+		if b == '\n' {
+			f.lines = append(f.lines, i+1)
+		}
+	}
+	// 最初の行の改行で判断する
+	if i := bytes.IndexByte(data, '\n'); i > 0 && data[i-1] == '\r' {
+		f.crlf = true
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var document yaml.Node
+		if err := decoder.Decode(&document); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse yaml. error: %v", err)
+		}
+
+		if len(document.Content) == 0 {
+			continue
+		}
+		f.Documents = append(f.Documents, document.Content[0])
+	}
+
+	return f, nil
+}
+
+// Lookup はmappingからkeyに対応する値のノードを返します
+func Lookup(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+
+	return nil
+}
+
+// SetScalar はスカラーの値を書き換えます。クォートのスタイルは元のものに合わせます
+func (f *File) SetScalar(node *yaml.Node, value string) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("node is not scalar. line: %d", node.Line)
+	}
+
+	if node.Value == value {
+		return nil
+	}
+
+	start := f.offset(node.Line, node.Column)
+
+	end, err := f.valueEnd(node, start)
+	if err != nil {
+		return err
+	}
+
+	text := render(node.Style, value)
+	// `key:` のように値が空の場合はキーの直後に値を書く
+	if node.Tag == "!!null" && start == end {
+		text = " " + render(0, value)
+	}
+
+	f.replace(edit{start: start, end: end, text: text, value: true})
+	node.Value = value
+	return nil
+}
+
+// SetField はmappingのkeyの値を書き換えます。keyがない場合はmappingの最後に追加します
+func (f *File) SetField(mapping *yaml.Node, key, value string) error {
+	return f.Set(mapping, Field{Key: key, Value: value})
+}
+
+// Set はmappingのfield.Keyの値とコメントを書き換えます。keyがない場合はmappingの最後に追加します
+func (f *File) Set(mapping *yaml.Node, field Field) error {
+	node := Lookup(mapping, field.Key)
+	if node == nil {
+		return f.insertFields(mapping, []Field{field})
+	}
+
+	if err := f.SetScalar(node, field.Value); err != nil {
+		return err
+	}
+
+	if field.Comment == "" {
+		return nil
+	}
+	return f.SetComment(node, field.Comment)
+}
+
+// SetComment はスカラーの行末のコメントを書き換えます。コメントがない場合は追加します
+func (f *File) SetComment(node *yaml.Node, comment string) error {
+	if node.Kind != yaml.ScalarNode || (node.Tag == "!!null" && node.Value == "") {
+		return fmt.Errorf("node is not scalar. line: %d", node.Line)
+	}
+
+	if node.LineComment == "# "+comment {
+		return nil
+	}
+
+	end, err := f.valueEnd(node, f.offset(node.Line, node.Column))
+	if err != nil {
+		return err
+	}
+
+	eol := len(f.src)
+	if i := bytes.IndexByte(f.src[end:], '\n'); i >= 0 {
+		eol = end + i
+	}
+	// CRLFの場合は\rを残す
+	if eol > end && f.src[eol-1] == '\r' {
+		eol--
+	}
+
+	rest := strings.TrimSpace(string(f.src[end:eol]))
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unsupported line. line: %d", node.Line)
+	}
+
+	f.replace(edit{start: end, end: eol, text: " # " + comment})
+	node.LineComment = "# " + comment
+	return nil
+}
+
+// DeleteField はmappingからkeyの行を削除します
+// 値が1行のスカラーで、キーが行の先頭にある場合だけ削除できます
+func (f *File) DeleteField(mapping *yaml.Node, key string) error {
+	if mapping == nil || mapping.Kind != yaml.MappingNode || mapping.Style&yaml.FlowStyle != 0 {
+		return fmt.Errorf("node is not block mapping")
+	}
+
+	keyNode, value := lookupPair(mapping, key)
+	if keyNode == nil {
+		return nil
+	}
+
+	start := f.lines[keyNode.Line-1]
+	if strings.TrimSpace(string(f.src[start:f.offset(keyNode.Line, keyNode.Column)])) != "" {
+		return fmt.Errorf("key is not at the beginning of the line. line: %d key: %s", keyNode.Line, key)
+	}
+
+	if value.Kind != yaml.ScalarNode || f.lastLine(value) != keyNode.Line {
+		return fmt.Errorf("value is not single line scalar. line: %d key: %s", keyNode.Line, key)
+	}
+
+	end := len(f.src)
+	if keyNode.Line < len(f.lines) {
+		end = f.lines[keyNode.Line]
+	}
+
+	f.edits = append(f.edits, edit{start: start, end: end, text: ""})
+	return nil
+}
+
+// AppendMapping はmappingのkeyのシーケンスにfieldsを持つmappingを追加します
+// keyがない場合はシーケンスごと追加します
+func (f *File) AppendMapping(mapping *yaml.Node, key string, fields []Field) error {
+	return f.appendItem(mapping, key, func(indent int) string {
+		return sequenceItem(indent, fields)
+	})
+}
+
+// AppendScalar はmappingのkeyのシーケンスに値を追加します
+// keyがない場合はシーケンスごと追加します
+func (f *File) AppendScalar(mapping *yaml.Node, key, value string) error {
+	return f.appendItem(mapping, key, func(indent int) string {
+		return strings.Repeat(" ", indent) + "- " + render(0, value) + "\n"
+	})
+}
+
+// appendItem はmappingのkeyのシーケンスにitemを追加します
+// itemはインデントを受け取り、改行で終わる要素を返します
+func (f *File) appendItem(mapping *yaml.Node, key string, item func(indent int) string) error {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf("node is not mapping")
+	}
+
+	keyNode, sequence := lookupPair(mapping, key)
+	if sequence == nil {
+		if mapping.Style&yaml.FlowStyle != 0 {
+			return fmt.Errorf("flow style mapping is not supported. line: %d", mapping.Line)
+		}
+
+		indent := mapping.Column - 1
+		text := strings.Repeat(" ", indent) + key + ":\n" + item(indent)
+		at := f.lineEnd(f.lastLine(mapping))
+		f.edits = append(f.edits, edit{start: at, end: at, text: text})
+		return nil
+	}
+
+	switch {
+	case sequence.Kind == yaml.SequenceNode && sequence.Style&yaml.FlowStyle == 0:
+		at := f.lineEnd(f.lastLine(sequence))
+		f.edits = append(f.edits, edit{start: at, end: at, text: item(sequence.Column - 1)})
+	case sequence.Kind == yaml.SequenceNode && len(sequence.Content) == 0:
+		// images: [] はブロックのシーケンスに置き換える。行末のコメントはキーの行に残す
+		start := f.offset(sequence.Line, sequence.Column)
+		end := bytes.IndexByte(f.src[start:], ']')
+		if end < 0 {
+			return fmt.Errorf("invalid flow sequence. line: %d", sequence.Line)
+		}
+		f.replaceWithItem(keyNode, sequence, start, start+end+1, item)
+	case sequence.Kind == yaml.ScalarNode && sequence.Tag == "!!null":
+		start := f.offset(sequence.Line, sequence.Column)
+		f.replaceWithItem(keyNode, sequence, start, start+len(sequence.Value), item)
+	default:
+		return fmt.Errorf("%s is not block sequence. line: %d", key, sequence.Line)
+	}
+
+	return nil
+}
+
+// replaceWithItem はキーの値[start, end)を消し、キーの次の行にitemを追加します
+func (f *File) replaceWithItem(keyNode, value *yaml.Node, start, end int, item func(indent int) string) {
+	for start > 0 && f.src[start-1] == ' ' {
+		start--
+	}
+	f.edits = append(f.edits, edit{start: start, end: end, text: ""})
+
+	at := f.lineEnd(value.Line)
+	f.edits = append(f.edits, edit{start: at, end: at, text: item(keyNode.Column - 1)})
+}
+
+// Changed は変更があるかを返します
+func (f *File) Changed() bool {
+	return len(f.edits) != 0
+}
+
+// Bytes は変更を反映した内容を返します
+func (f *File) Bytes() []byte {
+	edits := make([]edit, len(f.edits))
+	copy(edits, f.edits)
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].start < edits[j].start
+	})
+
+	var out bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		out.Write(f.src[last:e.start])
+		if f.crlf {
+			out.WriteString(strings.ReplaceAll(e.text, "\n", "\r\n"))
+		} else {
+			out.WriteString(e.text)
+		}
+		last = e.end
+	}
+	out.Write(f.src[last:])
+
+	return out.Bytes()
+}
+
+func (f *File) insertFields(mapping *yaml.Node, fields []Field) error {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf("node is not mapping")
+	}
+
+	if mapping.Style&yaml.FlowStyle != 0 {
+		return fmt.Errorf("flow style mapping is not supported. line: %d", mapping.Line)
+	}
+
+	indent := strings.Repeat(" ", mapping.Column-1)
+	var text strings.Builder
+	for _, field := range fields {
+		fmt.Fprintf(&text, "%s%s\n", indent, field.render())
+	}
+
+	at := f.lineEnd(f.lastLine(mapping))
+	f.edits = append(f.edits, edit{start: at, end: at, text: text.String()})
+	return nil
+}
+
+// offset は1始まりの行と列からバイト列のoffsetを返します
+func (f *File) offset(line, column int) int {
+	if line-1 >= len(f.lines) {
+		return len(f.src)
+	}
+
+	offset := f.lines[line-1]
+	for i := 1; i < column && offset < len(f.src); i++ {
+		_, size := utf8.DecodeRune(f.src[offset:])
+		offset += size
+	}
+
+	return offset
+}
+
+// lineEnd は行の次の行の先頭のoffsetを返します。最終行に改行がない場合は改行を足します
+func (f *File) lineEnd(line int) int {
+	if line < len(f.lines) {
+		return f.lines[line]
+	}
+
+	if len(f.src) != 0 && f.src[len(f.src)-1] != '\n' && !f.newline {
+		f.newline = true
+		f.edits = append(f.edits, edit{start: len(f.src), end: len(f.src), text: "\n"})
+	}
+	return len(f.src)
+}
+
+// valueEnd は元のバイト列でのスカラーの終わりのoffsetを返します
+// SetScalarで書き換えたノードはnode.Valueが元の値と違うため、記録した編集の範囲を使います
+func (f *File) valueEnd(node *yaml.Node, start int) (int, error) {
+	for _, e := range f.edits {
+		if e.start == start && e.value {
+			return e.end, nil
+		}
+	}
+
+	if node.Tag == "!!null" && node.Value == "" {
+		return start, nil
+	}
+
+	return f.scalarEnd(node, start)
+}
+
+// replace は編集を追加します。同じ範囲の同じ種類の編集がある場合は上書きします
+func (f *File) replace(e edit) {
+	for i := range f.edits {
+		if f.edits[i].start == e.start && f.edits[i].end == e.end && f.edits[i].value == e.value {
+			f.edits[i] = e
+			return
+		}
+	}
+
+	f.edits = append(f.edits, e)
+}
+
+func (f *File) scalarEnd(node *yaml.Node, start int) (int, error) {
+	switch {
+	case node.Style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(f.src); i++ {
+			switch f.src[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, nil
+			}
+		}
+	case node.Style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(f.src); i++ {
+			if f.src[i] != '\'' {
+				continue
+			}
+			if i+1 < len(f.src) && f.src[i+1] == '\'' {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) == 0:
+		end := start + len(node.Value)
+		if end <= len(f.src) && string(f.src[start:end]) == node.Value {
+			return end, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unsupported scalar. line: %d value: %s", node.Line, node.Value)
+}
+
+func lookupPair(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+
+	return nil, nil
+}
+
+// lastLine はノードが使っている最後の行を返します
+func (f *File) lastLine(node *yaml.Node) int {
+	line := node.Line
+	if node.Kind == yaml.ScalarNode {
+		if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+			line = f.blockScalarEnd(node)
+		} else {
+			line += strings.Count(node.Value, "\n")
+		}
+	}
+
+	for _, child := range node.Content {
+		if l := f.lastLine(child); l > line {
+			line = l
+		}
+	}
+
+	return line
+}
+
+// blockScalarEnd はブロックスカラー(| や >)の最後の行を返します
+// 値からは行数が分からないため、ヘッダの行よりインデントが深い行が続くところまでを範囲にします
+// 末尾の空行は |+ の場合だけ値に含めます
+func (f *File) blockScalarEnd(node *yaml.Node) int {
+	header := f.lineText(node.Line)
+	headerIndent := len(header) - len(strings.TrimLeft(header, " "))
+	// e.g. |+ や >2+
+	indicator := strings.Fields(header[f.offset(node.Line, node.Column)-f.lines[node.Line-1]:])[0]
+	keep := strings.Contains(indicator, "+")
+
+	end := node.Line
+	indent := -1
+	for line := node.Line + 1; line <= len(f.lines) && f.lines[line-1] < len(f.src); line++ {
+		text := f.lineText(line)
+		if strings.TrimSpace(text) == "" {
+			if keep {
+				end = line
+			}
+			continue
+		}
+
+		lineIndent := len(text) - len(strings.TrimLeft(text, " "))
+		if indent < 0 {
+			if lineIndent <= headerIndent {
+				break
+			}
+			indent = lineIndent
+		}
+		if lineIndent < indent {
+			break
+		}
+		end = line
+	}
+
+	return end
+}
+
+// lineText は1始まりの行の内容を改行を除いて返します
+func (f *File) lineText(line int) string {
+	start := f.lines[line-1]
+	end := len(f.src)
+	if line < len(f.lines) {
+		end = f.lines[line]
+	}
+
+	return strings.TrimRight(string(f.src[start:end]), "\r\n")
+}
+
+func sequenceItem(indent int, fields []Field) string {
+	var text strings.Builder
+	for i, field := range fields {
+		prefix := strings.Repeat(" ", indent) + "  "
+		if i == 0 {
+			prefix = strings.Repeat(" ", indent) + "- "
+		}
+		fmt.Fprintf(&text, "%s%s\n", prefix, field.render())
+	}
+
+	return text.String()
+}
+
+func (field Field) render() string {
+	line := field.Key + ": " + render(0, field.Value)
+	if field.Comment != "" {
+		line += " # " + field.Comment
+	}
+
+	return line
+}
+
+// render は値をスタイルに合わせて書き出します
+// プレーンで書くと文字列以外として読まれる値(e.g. 1234)はクォートします
+func render(style yaml.Style, value string) string {
+	switch {
+	case style&yaml.DoubleQuotedStyle != 0:
+		return strconv.Quote(value)
+	case style&yaml.SingleQuotedStyle != 0:
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	}
+
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return strconv.Quote(value)
+	}
+
+	return strings.TrimSuffix(string(out), "\n")
+}
@@ -0,0 +1,510 @@
+package yamledit
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parse はテスト用にYAMLを読み込みます
+func parse(t *testing.T, input string) *File {
+	t.Helper()
+
+	f, err := Parse([]byte(input))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	return f
+}
+
+// mappingOf はドキュメントのmappingを返します。シーケンスの場合は最初の要素を返します
+func mappingOf(document *yaml.Node) *yaml.Node {
+	if document.Kind == yaml.SequenceNode {
+		return document.Content[0]
+	}
+
+	return document
+}
+
+func assertBytes(t *testing.T, f *File, want string) {
+	t.Helper()
+
+	if got := string(f.Bytes()); got != want {
+		t.Errorf("Bytes()\ngot:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestSetScalar(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		value string
+		want  string
+	}{
+		{
+			name:  "プレーン",
+			input: "tag: v1 # current\n",
+			value: "v2",
+			want:  "tag: v2 # current\n",
+		},
+		{
+			name:  "ダブルクォート",
+			input: "tag: \"v1\"\n",
+			value: "v2",
+			want:  "tag: \"v2\"\n",
+		},
+		{
+			name:  "エスケープを含むダブルクォート",
+			input: "tag: \"v\\\"1\" # current\n",
+			value: "v2",
+			want:  "tag: \"v2\" # current\n",
+		},
+		{
+			name:  "シングルクォート",
+			input: "tag: 'v1'\n",
+			value: "it's",
+			want:  "tag: 'it''s'\n",
+		},
+		{
+			name:  "数値として読まれる値はクォートする",
+			input: "tag: v1\n",
+			value: "1234",
+			want:  "tag: \"1234\"\n",
+		},
+		{
+			name:  "値が空",
+			input: "tag:\nother: x\n",
+			value: "v2",
+			want:  "tag: v2\nother: x\n",
+		},
+		{
+			name:  "同じ値の場合は変更しない",
+			input: "tag: v1\n",
+			value: "v1",
+			want:  "tag: v1\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := parse(t, tt.input)
+			if err := f.SetScalar(Lookup(f.Documents[0], "tag"), tt.value); err != nil {
+				t.Fatalf("SetScalar() error = %v", err)
+			}
+			assertBytes(t, f, tt.want)
+		})
+	}
+}
+
+func TestSetComment(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		comment string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "コメントを追加する",
+			input:   "digest: sha256:0123\nnext: x\n",
+			comment: "v1",
+			want:    "digest: sha256:0123 # v1\nnext: x\n",
+		},
+		{
+			name:    "コメントを書き換える",
+			input:   "digest: sha256:0123   # v0\n",
+			comment: "v1",
+			want:    "digest: sha256:0123 # v1\n",
+		},
+		{
+			name:    "最終行に改行がない",
+			input:   "digest: sha256:0123",
+			comment: "v1",
+			want:    "digest: sha256:0123 # v1",
+		},
+		{
+			name:    "クォートした値",
+			input:   "digest: 'sha256:0123' # v0\n",
+			comment: "v1",
+			want:    "digest: 'sha256:0123' # v1\n",
+		},
+		{
+			name:    "値の後ろにコメント以外がある場合はエラー",
+			input:   "digest: {a: sha256:0123, b: c}\n",
+			comment: "v1",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := parse(t, tt.input)
+			node := Lookup(f.Documents[0], "digest")
+			if tt.wantErr {
+				node = Lookup(node, "a")
+			}
+
+			err := f.SetComment(node, tt.comment)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("SetComment() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("SetComment() error = %v", err)
+			}
+			assertBytes(t, f, tt.want)
+		})
+	}
+}
+
+func TestSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		input  string
+		fields []Field
+		want   string
+	}{
+		{
+			name:   "値とコメントを書き換える",
+			input:  "digest: sha256:0000 # v1\n",
+			fields: []Field{{Key: "digest", Value: "sha256:0123", Comment: "v2"}},
+			want:   "digest: sha256:0123 # v2\n",
+		},
+		{
+			name:   "クォートした値とコメントを書き換える",
+			input:  "digest: \"sha256:0000\" # v1\n",
+			fields: []Field{{Key: "digest", Value: "sha256:0123", Comment: "v2"}},
+			want:   "digest: \"sha256:0123\" # v2\n",
+		},
+		{
+			name:   "値が空",
+			input:  "digest:\nname: app\n",
+			fields: []Field{{Key: "digest", Value: "sha256:0123", Comment: "v2"}},
+			want:   "digest: sha256:0123 # v2\nname: app\n",
+		},
+		{
+			name:  "同じキーを2回書き換える",
+			input: "digest: sha256:0000 # v1\n",
+			fields: []Field{
+				{Key: "digest", Value: "sha256:0123", Comment: "v2"},
+				{Key: "digest", Value: "sha256:4567", Comment: "v3"},
+			},
+			want: "digest: sha256:4567 # v3\n",
+		},
+		{
+			name:   "キーがない場合は追加する",
+			input:  "name: app\n",
+			fields: []Field{{Key: "digest", Value: "sha256:0123", Comment: "v2"}},
+			want:   "name: app\ndigest: sha256:0123 # v2\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := parse(t, tt.input)
+			for _, field := range tt.fields {
+				if err := f.Set(f.Documents[0], field); err != nil {
+					t.Fatalf("Set() error = %v", err)
+				}
+			}
+			assertBytes(t, f, tt.want)
+		})
+	}
+}
+
+func TestDeleteField(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "行を削除する",
+			input: "- name: app\n  newTag: v1 # current\n  digest: sha256:0123\n",
+			key:   "newTag",
+			want:  "- name: app\n  digest: sha256:0123\n",
+		},
+		{
+			name:  "最終行に改行がない",
+			input: "name: app\nnewTag: v1",
+			key:   "newTag",
+			want:  "name: app\n",
+		},
+		{
+			name:  "キーがない場合は何もしない",
+			input: "name: app\n",
+			key:   "newTag",
+			want:  "name: app\n",
+		},
+		{
+			name:    "キーが行の先頭にない場合はエラー",
+			input:   "- newTag: v1\n  name: app\n",
+			key:     "newTag",
+			wantErr: true,
+		},
+		{
+			name:    "値が複数行の場合はエラー",
+			input:   "newTag:\n  a: b\nname: app\n",
+			key:     "newTag",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := parse(t, tt.input)
+			err := f.DeleteField(mappingOf(f.Documents[0]), tt.key)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("DeleteField() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("DeleteField() error = %v", err)
+			}
+			assertBytes(t, f, tt.want)
+		})
+	}
+}
+
+func TestAppendMapping(t *testing.T) {
+	fields := []Field{
+		{Key: "name", Value: "app"},
+		{Key: "newTag", Value: "v2", Comment: "added"},
+	}
+
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "キーがない",
+			input: "resources:\n- ../../base/\n",
+			want:  "resources:\n- ../../base/\nimages:\n- name: app\n  newTag: v2 # added\n",
+		},
+		{
+			name:  "キーがなく最終行に改行がない",
+			input: "resources:\n- ../../base/",
+			want:  "resources:\n- ../../base/\nimages:\n- name: app\n  newTag: v2 # added\n",
+		},
+		{
+			name:  "空のflowシーケンス",
+			input: "images: [] # none\nresources:\n- ../../base/\n",
+			want:  "images: # none\n- name: app\n  newTag: v2 # added\nresources:\n- ../../base/\n",
+		},
+		{
+			name:  "値が空",
+			input: "images:\nresources:\n- ../../base/\n",
+			want:  "images:\n- name: app\n  newTag: v2 # added\nresources:\n- ../../base/\n",
+		},
+		{
+			name:  "null",
+			input: "images: null\nresources:\n- ../../base/\n",
+			want:  "images:\n- name: app\n  newTag: v2 # added\nresources:\n- ../../base/\n",
+		},
+		{
+			name:  "インデントしたシーケンス",
+			input: "images:\n  - name: other\n    newTag: v1\nresources:\n  - ../../base/\n",
+			want:  "images:\n  - name: other\n    newTag: v1\n  - name: app\n    newTag: v2 # added\nresources:\n  - ../../base/\n",
+		},
+		{
+			name:  "インデントしていないシーケンス",
+			input: "images:\n- name: other\n  newTag: v1\n",
+			want:  "images:\n- name: other\n  newTag: v1\n- name: app\n  newTag: v2 # added\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := parse(t, tt.input)
+			if err := f.AppendMapping(f.Documents[0], "images", fields); err != nil {
+				t.Fatalf("AppendMapping() error = %v", err)
+			}
+			assertBytes(t, f, tt.want)
+		})
+	}
+}
+
+func TestAppendMappingNotSequence(t *testing.T) {
+	f := parse(t, "images: app\n")
+	if err := f.AppendMapping(f.Documents[0], "images", []Field{{Key: "name", Value: "app"}}); err == nil {
+		t.Fatalf("AppendMapping() error = nil, want error")
+	}
+}
+
+func TestMultiDocument(t *testing.T) {
+	const input = `kind: Deployment
+spec:
+  image: app:v1
+---
+# comment
+kind: CronJob
+spec:
+  image: app:v1
+`
+	const want = `kind: Deployment
+spec:
+  image: app:v1
+---
+# comment
+kind: CronJob
+spec:
+  image: app:v2
+`
+
+	f := parse(t, input)
+	if len(f.Documents) != 2 {
+		t.Fatalf("documents = %d, want 2", len(f.Documents))
+	}
+
+	if err := f.SetScalar(Lookup(Lookup(f.Documents[1], "spec"), "image"), "app:v2"); err != nil {
+		t.Fatalf("SetScalar() error = %v", err)
+	}
+	assertBytes(t, f, want)
+}
+
+func TestCRLF(t *testing.T) {
+	const input = "images:\r\n- name: app # app\r\n  newTag: v1\r\n  digest: sha256:0000 # v1\r\n"
+
+	f := parse(t, input)
+	image := Lookup(f.Documents[0], "images").Content[0]
+
+	if err := f.Set(image, Field{Key: "digest", Value: "sha256:0123", Comment: "v2"}); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if err := f.SetComment(Lookup(image, "name"), "renamed"); err != nil {
+		t.Fatalf("SetComment() error = %v", err)
+	}
+	if err := f.DeleteField(image, "newTag"); err != nil {
+		t.Fatalf("DeleteField() error = %v", err)
+	}
+	if err := f.AppendMapping(f.Documents[0], "images", []Field{{Key: "name", Value: "worker"}, {Key: "newTag", Value: "v2"}}); err != nil {
+		t.Fatalf("AppendMapping() error = %v", err)
+	}
+	if err := f.SetField(f.Documents[0], "namespace", "samples"); err != nil {
+		t.Fatalf("SetField() error = %v", err)
+	}
+
+	assertBytes(t, f, "images:\r\n- name: app # renamed\r\n  digest: sha256:0123 # v2\r\n- name: worker\r\n  newTag: v2\r\nnamespace: samples\r\n")
+}
+
+func TestBlockScalar(t *testing.T) {
+	// 各ケースの入力は最後のキーの値がブロックスカラーです
+	blocks := []struct {
+		name  string
+		block string
+	}{
+		{name: "|", block: "  note: |\n    line1\n    line2\n"},
+		{name: "|-", block: "  note: |-\n    line1\n\n    line2\n"},
+		{name: "|+", block: "  note: |+\n    line1\n    line2\n\n"},
+		{name: ">", block: "  note: >\n    line1\n    line2\n"},
+	}
+
+	tests := []struct {
+		name   string
+		input  func(block string) string
+		edit   func(f *File) error
+		output func(block string) string
+	}{
+		{
+			name:  "Setでキーを追加する",
+			input: func(block string) string { return "image:\n  repository: app\n" + block + "other: x\n" },
+			edit: func(f *File) error {
+				return f.Set(Lookup(f.Documents[0], "image"), Field{Key: "tag", Value: "v2"})
+			},
+			output: func(block string) string {
+				return "image:\n  repository: app\n" + block + "  tag: v2\nother: x\n"
+			},
+		},
+		{
+			name:  "SetPathでキーを追加する",
+			input: func(block string) string { return "image:\n  repository: app\n" + block },
+			edit: func(f *File) error {
+				_, err := f.SetPath(f.Documents[0], ".image.tag", "v2")
+				return err
+			},
+			output: func(block string) string {
+				return "image:\n  repository: app\n" + block + "  tag: v2\n"
+			},
+		},
+		{
+			name: "AppendMappingでキーごと追加する",
+			input: func(block string) string {
+				return "patches:\n- target:\n    kind: Deployment\n" + strings.ReplaceAll(block, "  ", "    ")
+			},
+			edit: func(f *File) error {
+				return f.AppendMapping(f.Documents[0], "images", []Field{{Key: "name", Value: "app"}})
+			},
+			output: func(block string) string {
+				return "patches:\n- target:\n    kind: Deployment\n" + strings.ReplaceAll(block, "  ", "    ") + "images:\n- name: app\n"
+			},
+		},
+		{
+			name:  "AppendMappingでシーケンスに追加する",
+			input: func(block string) string { return "patches:\n- path: a.yaml\n" + block + "kind: Kustomization\n" },
+			edit: func(f *File) error {
+				return f.AppendMapping(f.Documents[0], "patches", []Field{{Key: "path", Value: "b.yaml"}})
+			},
+			output: func(block string) string {
+				return "patches:\n- path: a.yaml\n" + block + "- path: b.yaml\nkind: Kustomization\n"
+			},
+		},
+		{
+			name:  "AppendScalarでキーごと追加する",
+			input: func(block string) string { return "spec:\n" + block },
+			edit: func(f *File) error {
+				return f.AppendScalar(Lookup(f.Documents[0], "spec"), "images", "app:v2")
+			},
+			output: func(block string) string {
+				return "spec:\n" + block + "  images:\n  - app:v2\n"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		for _, b := range blocks {
+			t.Run(tt.name+" "+b.name, func(t *testing.T) {
+				input := tt.input(b.block)
+				f := parse(t, input)
+				if err := tt.edit(f); err != nil {
+					t.Fatalf("edit error = %v", err)
+				}
+				assertBytes(t, f, tt.output(b.block))
+
+				// ブロックスカラーの値が変わっていないこと
+				before, after := parse(t, input), parse(t, string(f.Bytes()))
+				if got, want := blockValue(after), blockValue(before); got != want {
+					t.Errorf("block scalar = %q, want %q", got, want)
+				}
+			})
+		}
+	}
+}
+
+// blockValue はドキュメントの中のnoteの値を返します
+func blockValue(f *File) string {
+	var find func(node *yaml.Node) string
+	find = func(node *yaml.Node) string {
+		if node.Kind == yaml.MappingNode {
+			if note := Lookup(node, "note"); note != nil {
+				return note.Value
+			}
+		}
+		for _, child := range node.Content {
+			if v := find(child); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	return find(f.Documents[0])
+}